@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Alert is a finding that matched a watchlist entry
+type Alert struct {
+	Type     string    `json:"type"`
+	Match    string    `json:"match"`
+	Email    string    `json:"email,omitempty"`
+	Excerpt  string    `json:"excerpt,omitempty"`
+	Source   string    `json:"source"`
+	URL      string    `json:"url"`
+	Time     time.Time `json:"time"`
+	Campaign string    `json:"campaign,omitempty"`
+}
+
+// watchlist holds the addresses and domains to alert on. Entries without
+// an "@", or starting with one, match the domain and its subdomains.
+type watchlist struct {
+	addresses map[string]bool
+	domains   []string
+}
+
+// loadWatchlist reads one entry per line, ignoring blank lines and
+// #-comments
+func loadWatchlist(path string) (*watchlist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	w := &watchlist{addresses: make(map[string]bool)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		w.add(scanner.Text())
+	}
+	return w, scanner.Err()
+}
+
+// add adds entry, an address or a domain, returning it as kept or "" if it
+// is blank, a comment or already there
+func (w *watchlist) add(entry string) string {
+	entry = strings.ToLower(strings.TrimSpace(entry))
+	if entry == "" || strings.HasPrefix(entry, "#") {
+		return ""
+	}
+	if strings.HasPrefix(entry, "@") || !strings.Contains(entry, "@") {
+		domain := strings.TrimPrefix(entry, "@")
+		for _, d := range w.domains {
+			if d == domain {
+				return ""
+			}
+		}
+		w.domains = append(w.domains, domain)
+		return domain
+	}
+	if w.addresses[entry] {
+		return ""
+	}
+	w.addresses[entry] = true
+	return entry
+}
+
+// match returns the entry mail matches, or ""
+func (w *watchlist) match(mail string) string {
+	mail = strings.ToLower(mail)
+	if w.addresses[mail] {
+		return mail
+	}
+	domain := mail[strings.LastIndex(mail, "@")+1:]
+	for _, d := range w.domains {
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return d
+		}
+	}
+	return ""
+}
+
+// excerptContext is how much text around a keyword goes into its alert
+const excerptContext = 80
+
+// loadKeywords reads one keyword per line like loadWatchlist and returns a
+// case-insensitive regexp matching any of them
+func loadKeywords(path string) (*regexp.Regexp, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var alts []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		alts = append(alts, regexp.QuoteMeta(line))
+	}
+	if alts == nil {
+		return nil, fmt.Errorf("%s: no keywords", path)
+	}
+	return regexp.Compile(`(?i)` + strings.Join(alts, "|"))
+}
+
+// excerpt returns the text around page[start:end] on a single line
+func excerpt(page []byte, start, end int) string {
+	from, to := start-excerptContext, end+excerptContext
+	if from < 0 {
+		from = 0
+	}
+	if to > len(page) {
+		to = len(page)
+	}
+	for from > 0 && !utf8.RuneStart(page[from]) {
+		from++
+	}
+	for to < len(page) && !utf8.RuneStart(page[to]) {
+		to--
+	}
+	return string(bytes.Join(bytes.Fields(page[from:to]), []byte(" ")))
+}
+
+// scanKeywords raises an alert for every keyword found in page, once per
+// keyword and paste
+func (c *Crawler) scanKeywords(s *Source, url string, page []byte) {
+	if c.keywords == nil {
+		return
+	}
+	seen := make(map[string]bool)
+	now := time.Now()
+	for _, m := range c.keywords.FindAllIndex(page, -1) {
+		keyword := strings.ToLower(string(page[m[0]:m[1]]))
+		if seen[keyword] {
+			continue
+		}
+		seen[keyword] = true
+		text := c.redactText(excerpt(page, m[0], m[1]))
+		c.alert(Alert{
+			Type:     "keyword",
+			Match:    keyword,
+			Excerpt:  text,
+			Source:   s.Name,
+			URL:      url,
+			Time:     now,
+			Campaign: s.campaign,
+		},
+			fmt.Sprintf("mailbot alert: %q found on %s", keyword, s.Name),
+			fmt.Sprintf("Keyword %q was found in\n%s\nat %s:\n\n%s\n",
+				keyword, url, now.Format(time.RFC3339), text),
+		)
+	}
+}
+
+// alerts writes alerts to the -alerts file
+type alerts struct {
+	mu  sync.Mutex
+	out *os.File
+}
+
+// alert records a and queues it for every notifier, without waiting on any:
+// it runs on the sink and extract paths. The -alerts file is opened on the
+// first alert when no watchlist or keywords opened it.
+func (c *Crawler) alert(a Alert, subject, body string) {
+	c.alerts.mu.Lock()
+	if c.alerts.out == nil && c.flags.alerts != "" {
+		f, err := os.OpenFile(c.flags.alerts, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+		if err != nil {
+			report(err)
+		}
+		c.alerts.out = f
+	}
+	if c.alerts.out != nil {
+		b, _ := json.Marshal(a)
+		if err := appendLines(c.alerts.out, append(b, '\n')); err != nil {
+			report(err)
+		}
+	}
+	c.alerts.mu.Unlock()
+	c.notify(subject, body)
+}
+
+// watch raises an alert for every finding on the watchlist. mail is the
+// address of f, which may be redacted.
+func (c *Crawler) watch(f Finding, mail string) {
+	if c.watchlist == nil {
+		return
+	}
+	match := c.watchlist.match(mail)
+	if match == "" {
+		return
+	}
+	c.alert(Alert{
+		Type:     "watchlist",
+		Match:    match,
+		Email:    f.Email,
+		Source:   f.Source,
+		URL:      f.URL,
+		Time:     f.Time,
+		Campaign: f.Campaign,
+	},
+		fmt.Sprintf("mailbot alert: %s found on %s", f.Email, f.Source),
+		fmt.Sprintf("%s, matching watchlist entry %q, was found in\n%s\nat %s.\n",
+			f.Email, match, f.URL, f.Time.Format(time.RFC3339)),
+	)
+}
+
+// webhookNotifier posts messages as {"text": ...} JSON, which Slack,
+// Mattermost and most chat bridges accept. Each post must end within the
+// client's timeout.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (n *webhookNotifier) Notify(subject, body string) error {
+	b, _ := json.Marshal(map[string]string{"text": "*" + subject + "*\n" + body})
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook: %s", resp.Status)
+	}
+	return nil
+}
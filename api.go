@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// queued is a URL added to the fetch queue through the control API
+type queued struct {
+	source *Source
+	url    string
+}
+
+// serveAPI serves the control API on addr until it fails. Every request
+// must carry "Authorization: Bearer <token>".
+func (c *Crawler) serveAPI(addr, token string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pause", c.post(func(r *http.Request) (interface{}, error) {
+		return c.setPaused(r.FormValue("source"), true)
+	}))
+	mux.HandleFunc("/resume", c.post(func(r *http.Request) (interface{}, error) {
+		return c.setPaused(r.FormValue("source"), false)
+	}))
+	mux.HandleFunc("/rate-limit", c.post(func(r *http.Request) (interface{}, error) {
+		delay, err := time.ParseDuration(r.FormValue("delay"))
+		if err != nil || delay < 0 {
+			return nil, fmt.Errorf("delay: invalid duration %q", r.FormValue("delay"))
+		}
+		targets, err := selectSources(r.FormValue("source"))
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range targets {
+			s.setRateLimit(delay)
+		}
+		return map[string]string{"rate_limit": delay.String()}, nil
+	}))
+	mux.HandleFunc("/enqueue", c.post(func(r *http.Request) (interface{}, error) {
+		url := r.FormValue("url")
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			return nil, errors.New("url: must be an http or https URL")
+		}
+		s := lookupSource(r.FormValue("source"))
+		if s == nil {
+			return nil, fmt.Errorf("source: unknown source %q", r.FormValue("source"))
+		}
+		select {
+		case c.queue <- queued{s, url}:
+			return map[string]int{"queued": len(c.queue)}, nil
+		default:
+			return nil, errQueueFull
+		}
+	}))
+	mux.HandleFunc("/cycle", c.post(func(r *http.Request) (interface{}, error) {
+		select {
+		case c.wake <- struct{}{}:
+		default:
+			// A cycle is already pending
+		}
+		return map[string]bool{"triggered": true}, nil
+	}))
+	mux.HandleFunc("/suppress", c.post(func(r *http.Request) (interface{}, error) {
+		r.ParseForm()
+		entries := r.Form["entry"]
+		if len(entries) == 0 {
+			return nil, errors.New("entry: missing address or domain")
+		}
+		added, scrubbed, err := c.suppress(entries)
+		if err != nil {
+			return nil, err
+		}
+		if added == nil {
+			added = []string{}
+		}
+		return map[string]interface{}{"added": added, "scrubbed": scrubbed}, nil
+	}))
+	mux.HandleFunc("/stats", c.serveStats)
+
+	srv := &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			mux.ServeHTTP(w, r)
+		}),
+	}
+	c.listen("control api", srv)
+}
+
+var errQueueFull = errors.New("fetch queue is full")
+
+// post wraps a control action into a handler that only accepts POST and
+// answers with JSON
+func (c *Crawler) post(action func(*http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		v, err := action(r)
+		if err != nil {
+			status := http.StatusBadRequest
+			if err == errQueueFull {
+				status = http.StatusServiceUnavailable
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, v)
+	}
+}
+
+// setPaused pauses or resumes the source called name, or the whole crawler
+// when name is empty
+func (c *Crawler) setPaused(name string, paused bool) (interface{}, error) {
+	if name == "" {
+		var v int32
+		if paused {
+			v = 1
+		}
+		atomic.StoreInt32(&c.paused, v)
+		return map[string]bool{"paused": paused}, nil
+	}
+	s := lookupSource(name)
+	if s == nil {
+		return nil, fmt.Errorf("source: unknown source %q", name)
+	}
+	s.setPaused(paused)
+	return map[string]interface{}{"source": name, "paused": paused}, nil
+}
+
+// selectSources returns the source called name, or all of them when name
+// is empty
+func selectSources(name string) ([]*Source, error) {
+	if name == "" {
+		return sources, nil
+	}
+	s := lookupSource(name)
+	if s == nil {
+		return nil, fmt.Errorf("source: unknown source %q", name)
+	}
+	return []*Source{s}, nil
+}
+
+// drainQueue fetches the URLs added through the control API as they come
+func (c *Crawler) drainQueue() {
+	for {
+		var q queued
+		select {
+		case q = <-c.queue:
+		case <-c.ctx.Done():
+			return
+		}
+		page, err := c.FetchPage(q.source, q.url)
+		if err != nil {
+			report(err)
+			continue
+		}
+		c.count(metricPastes, q.source.Name, 1)
+		c.GetMail(q.source, q.url, page)
+	}
+}
+
+// apiToken returns the control API token from -api-token or the
+// MAILBOT_API_TOKEN environment variable
+func (c *Crawler) apiToken() (string, error) {
+	token := c.flags.apiToken
+	if token == "" {
+		token = os.Getenv("MAILBOT_API_TOKEN")
+	}
+	if token == "" {
+		return "", errors.New("-api, -grpc, -web and -coordinator-url need a token, set -api-token or MAILBOT_API_TOKEN")
+	}
+	return token, nil
+}
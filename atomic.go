@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// appendLines appends b, whole lines, to f, opened with O_APPEND, in a
+// single write, so that the lines of two writers never interleave. If the
+// write fails part way, as when the disk fills up, what it wrote is cut off
+// again so no torn line is left behind. Callers serialize their writes to
+// f.
+func appendLines(f *os.File, b []byte) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	n, err := f.Write(b)
+	if err != nil && n > 0 {
+		f.Truncate(info.Size())
+	}
+	return err
+}
+
+// recoverLines cuts a torn last line off the file at path: one without its
+// newline, as left by a crash or kill in the middle of a write, that would
+// otherwise run into the next line appended
+func recoverLines(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	// Back from the end to the last newline
+	size, keep := info.Size(), int64(0)
+	buf := make([]byte, 64<<10)
+	for end := size; end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		chunk := buf[:end-start]
+		if _, err := f.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return err
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			keep = start + int64(i) + 1
+			break
+		}
+		end = start
+	}
+	if keep == size {
+		return nil
+	}
+	if err := f.Truncate(keep); err != nil {
+		return err
+	}
+	c.logf(0, "%s: dropped a torn last line of %d bytes", path, size-keep)
+	return nil
+}
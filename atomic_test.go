@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecoverLines(t *testing.T) {
+	setupTest(t)
+	long := strings.Repeat("x", 100<<10)
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"empty", "", ""},
+		{"whole lines", "a\nb\n", "a\nb\n"},
+		{"torn line", "a\nb\n{\"email\":", "a\nb\n"},
+		{"only a torn line", "{\"email\":", ""},
+		{"torn line longer than a read", "a\n" + long, "a\n"},
+		{"line longer than a read", long + "\n" + "torn", long + "\n"},
+	}
+	dir, err := ioutil.TempDir("", "mailbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, tt := range tests {
+		path := filepath.Join(dir, "lines")
+		if err := ioutil.WriteFile(path, []byte(tt.data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := recoverLines(path); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		got, _ := ioutil.ReadFile(path)
+		if string(got) != tt.want {
+			t.Errorf("%s: kept %d bytes, want %d", tt.name, len(got), len(tt.want))
+		}
+	}
+	if err := recoverLines(filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestAppendLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mailbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lines")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []string{"a\n", "b\nc\n", "d\n"} {
+		if err := appendLines(f, []byte(b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+	if got, _ := ioutil.ReadFile(path); string(got) != "a\nb\nc\nd\n" {
+		t.Errorf("wrote %q", got)
+	}
+
+	// A failed write leaves the file as it was
+	f, _ = os.Open(path)
+	if err := appendLines(f, []byte("e\n")); err == nil {
+		t.Errorf("write to a read-only file succeeded")
+	}
+	f.Close()
+	if got, _ := ioutil.ReadFile(path); string(got) != "a\nb\nc\nd\n" {
+		t.Errorf("after a failed write: %q", got)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditRecord is one line of the -audit log. Prev is the SHA-256 of the
+// previous line, chaining the records so that edits and deletions show.
+type auditRecord struct {
+	Seq      int64     `json:"seq"`
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Source   string    `json:"source"`
+	URL      string    `json:"url"`
+	Status   int       `json:"status,omitempty"`
+	Bytes    int       `json:"bytes,omitempty"`
+	Duration float64   `json:"duration_ms,omitempty"`
+	SHA256   string    `json:"sha256,omitempty"`
+	Email    string    `json:"email,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Campaign string    `json:"campaign,omitempty"`
+	Prev     string    `json:"prev"`
+}
+
+// audit is the append-only log of fetches and findings
+type audit struct {
+	mu   sync.Mutex
+	out  *os.File
+	seq  int64
+	prev string
+}
+
+// openAudit opens path for appending, continuing the chain of the records
+// it already holds
+func (c *Crawler) openAudit(path string) error {
+	if err := recoverLines(path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	// The last record is within the file's tail
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	offset := info.Size() - 64<<10
+	if offset < 0 {
+		offset = 0
+	}
+	tail := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(tail, offset); err != nil && err != io.EOF {
+		return err
+	}
+	tail = bytes.TrimRight(tail, "\n")
+	if len(tail) > 0 {
+		last := tail[bytes.LastIndexByte(tail, '\n')+1:]
+		var r auditRecord
+		if err := json.Unmarshal(last, &r); err != nil {
+			return err
+		}
+		sum := sha256.Sum256(last)
+		c.audit.seq, c.audit.prev = r.Seq, hex.EncodeToString(sum[:])
+	}
+	c.audit.out = f
+	return nil
+}
+
+// record appends r to the audit log, if there is one
+func (c *Crawler) record(r auditRecord) {
+	a := &c.audit
+	if a.out == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r.Seq, r.Prev = a.seq+1, a.prev
+	line, _ := json.Marshal(r)
+	if err := appendLines(a.out, append(line, '\n')); err != nil {
+		report(err)
+		return
+	}
+	// Only a written record continues the chain
+	sum := sha256.Sum256(line)
+	a.seq, a.prev = r.Seq, hex.EncodeToString(sum[:])
+}
+
+// auditFetch records a request made for url
+func (c *Crawler) auditFetch(s *Source, url string, start time.Time, status int, body []byte, err error) {
+	r := auditRecord{
+		Time:     start,
+		Type:     "fetch",
+		Source:   s.Name,
+		URL:      url,
+		Status:   status,
+		Bytes:    len(body),
+		Duration: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if body != nil {
+		r.SHA256 = hashPage(body)
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	c.record(r)
+}
+
+// hashPage returns the hex SHA-256 of a fetched page, which ties findings
+// to the fetch records of the page they were found in
+func hashPage(page []byte) string {
+	sum := sha256.Sum256(page)
+	return hex.EncodeToString(sum[:])
+}
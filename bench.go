@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// benchStages are the steps of the extraction pipeline timed by bench
+var benchStages = []string{"match", "filter", "dedup", "sink"}
+
+// Bench runs the pastes of a corpus directory through the extraction
+// pipeline and reports its throughput, allocations and per-stage timings.
+// The corpus is either recorded with -record or plain text files.
+func (c *Crawler) Bench(dir string) {
+	if dir == "" {
+		reportFatal(fmt.Errorf("usage: mailbot bench [flags] corpus-dir"))
+		os.Exit(exitConfig)
+	}
+	docs, size, err := loadCorpus(dir)
+	if err != nil {
+		reportFatal(err)
+		os.Exit(exitConfig)
+	}
+	if len(docs) == 0 {
+		reportFatal(fmt.Errorf("%s: no documents", dir))
+		os.Exit(exitConfig)
+	}
+	sink, err := ioutil.TempFile("", "mailbot-bench-")
+	if err != nil {
+		reportFatal(err)
+		os.Exit(exitSink)
+	}
+	defer os.Remove(sink.Name())
+	defer sink.Close()
+	c.store = newMemoryStore()
+
+	var (
+		stages        = make(map[string]time.Duration)
+		found, fresh  int
+		matches       [][]byte
+		before, after runtime.MemStats
+	)
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for _, doc := range docs {
+		t := time.Now()
+		if size := c.flags.chunkSize; size > 0 && len(doc) > size {
+			// Chunks are filtered as they are scanned
+			matches = scanLarge(matches[:0], doc, size)
+			stages["match"] += time.Since(t)
+			found += len(matches)
+			t = time.Now()
+		} else {
+			matches = scanMails(matches[:0], doc)
+			stages["match"] += time.Since(t)
+			found += len(matches)
+			t = time.Now()
+			matches = filterMails(matches)
+		}
+		mails := make([]string, len(matches))
+		for i, m := range matches {
+			mails[i] = string(m)
+		}
+		stages["filter"] += time.Since(t)
+
+		t = time.Now()
+		mails, _ = c.dedup("", mails)
+		stages["dedup"] += time.Since(t)
+		fresh += len(mails)
+
+		if len(mails) == 0 {
+			continue
+		}
+		t = time.Now()
+		if _, err := sink.WriteString(strings.Join(mails, "\n") + "\n"); err != nil {
+			reportFatal(err)
+			os.Exit(exitSink)
+		}
+		sink.Sync()
+		stages["sink"] += time.Since(t)
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "documents\t%d\t%.1f/s\n", len(docs), float64(len(docs))/elapsed.Seconds())
+	fmt.Fprintf(w, "bytes\t%d\t%.1f MB/s\n", size, float64(size)/1e6/elapsed.Seconds())
+	fmt.Fprintf(w, "addresses\t%d found\t%d new\n", found, fresh)
+	fmt.Fprintf(w, "elapsed\t%s\t\n", elapsed.Round(time.Microsecond))
+	fmt.Fprintf(w, "allocations\t%d\t%.1f/document\n", after.Mallocs-before.Mallocs, float64(after.Mallocs-before.Mallocs)/float64(len(docs)))
+	fmt.Fprintf(w, "allocated\t%d B\t%.1f B/document\n", after.TotalAlloc-before.TotalAlloc, float64(after.TotalAlloc-before.TotalAlloc)/float64(len(docs)))
+	for _, stage := range benchStages {
+		d := stages[stage]
+		fmt.Fprintf(w, "  %s\t%s\t%.1f%%\n", stage, d.Round(time.Microsecond), 100*float64(d)/float64(elapsed))
+	}
+	w.Flush()
+}
+
+// loadCorpus reads every document under dir into memory, so that reading
+// them isn't part of the timings. Recorded responses are reduced to their
+// body.
+func loadCorpus(dir string) (docs [][]byte, size int64, err error) {
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(path, ".http") {
+			resp, err := readFixture(data, nil)
+			if err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			data, err = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+		}
+		docs = append(docs, data)
+		size += int64(len(data))
+		return nil
+	})
+	return docs, size, err
+}
@@ -0,0 +1,41 @@
+package main
+
+import "sync"
+
+// broker fans new findings out to live subscribers
+type broker struct {
+	mu   sync.Mutex
+	subs map[chan Finding]bool
+}
+
+const subscriberBuffer = 256
+
+// subscribe returns a channel receiving every finding published from now
+// on, and a function to stop the subscription
+func (b *broker) subscribe() (<-chan Finding, func()) {
+	ch := make(chan Finding, subscriberBuffer)
+	b.mu.Lock()
+	if b.subs == nil {
+		b.subs = make(map[chan Finding]bool)
+	}
+	b.subs[ch] = true
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+// publish hands f to every subscriber. A subscriber whose buffer is full
+// misses f rather than stalling the crawler.
+func (b *broker) publish(f Finding) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- f:
+		default:
+		}
+	}
+}
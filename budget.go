@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+var errBudget = errors.New("request budget exhausted")
+
+// resetBudget starts a new cycle with full request budgets
+func (c *Crawler) resetBudget() {
+	atomic.StoreInt64(&c.requests, 0)
+	for _, s := range sources {
+		atomic.StoreInt64(&s.requests, 0)
+	}
+}
+
+// spend takes one request out of the cycle budgets of s and of the crawler.
+// It reports false, taking nothing, when either budget is used up.
+func (c *Crawler) spend(s *Source) bool {
+	if s.maxRequests > 0 && atomic.AddInt64(&s.requests, 1) > int64(s.maxRequests) {
+		atomic.AddInt64(&s.requests, -1)
+		return false
+	}
+	if c.flags.maxRequests > 0 && atomic.AddInt64(&c.requests, 1) > int64(c.flags.maxRequests) {
+		atomic.AddInt64(&c.requests, -1)
+		if s.maxRequests > 0 {
+			atomic.AddInt64(&s.requests, -1)
+		}
+		return false
+	}
+	return true
+}
+
+// exhausted reports whether no request budget is left for s this cycle
+func (c *Crawler) exhausted(s *Source) bool {
+	if s.maxRequests > 0 && atomic.LoadInt64(&s.requests) >= int64(s.maxRequests) {
+		return true
+	}
+	return c.flags.maxRequests > 0 && atomic.LoadInt64(&c.requests) >= int64(c.flags.maxRequests)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// A listing with more links than this is taken for a pattern matching far
+// more than paste links
+const maxListed = 1000
+
+// SourceCheck is the result of reading a source's archive once
+type SourceCheck struct {
+	Source    string `json:"source"`
+	Pass      bool   `json:"pass"`
+	Listed    int    `json:"listed"`
+	Malformed int    `json:"malformed"`
+	Duration  int64  `json:"duration_ms"`
+	Problem   string `json:"problem,omitempty"`
+}
+
+// saneLink reports whether the part of a link the Link pattern captured
+// looks like a paste path, and not a piece of markup
+func saneLink(link []byte) bool {
+	return len(link) > 0 && len(link) <= 200 && !bytes.ContainsAny(link, " \t\r\n<>\"'")
+}
+
+// checkSource fetches the archive of s once and checks what its Link
+// pattern makes of it
+func (c *Crawler) checkSource(s *Source) SourceCheck {
+	check := SourceCheck{Source: s.Name}
+	start := time.Now()
+	page, _, err := c.fetch(s, s.Archive)
+	check.Duration = time.Since(start).Milliseconds()
+	if err != nil {
+		check.Problem = err.Error()
+		return check
+	}
+	seen := make(map[string]bool)
+	for _, m := range s.Link.FindAllSubmatch(page, -1) {
+		if !saneLink(m[1]) {
+			check.Malformed++
+			continue
+		}
+		seen[string(m[1])] = true
+	}
+	check.Listed = len(seen)
+	switch {
+	case check.Listed == 0 && check.Malformed == 0:
+		check.Problem = fmt.Sprintf("no paste links in %d bytes, the page layout may have changed", len(page))
+	case check.Malformed > 0:
+		check.Problem = fmt.Sprintf("%d links don't look like paste paths", check.Malformed)
+	case check.Listed > maxListed:
+		check.Problem = fmt.Sprintf("%d links, more than an archive lists", check.Listed)
+	default:
+		check.Pass = true
+	}
+	return check
+}
+
+// CheckSources reads the archive of every enabled source once and reports
+// whether its parser still finds pastes there. It exits with
+// exitSourcesFailing if any doesn't.
+func (c *Crawler) CheckSources() {
+	var (
+		checks []SourceCheck
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+	)
+	for _, s := range sources {
+		if !s.enabled {
+			continue
+		}
+		wg.Add(1)
+		go func(s *Source) {
+			defer wg.Done()
+			check := c.checkSource(s)
+			mu.Lock()
+			checks = append(checks, check)
+			mu.Unlock()
+		}(s)
+	}
+	wg.Wait()
+	// In the order sources are declared
+	ordered := make([]SourceCheck, 0, len(checks))
+	for _, s := range sources {
+		for _, check := range checks {
+			if check.Source == s.Name {
+				ordered = append(ordered, check)
+			}
+		}
+	}
+	failed := 0
+	for _, check := range ordered {
+		if !check.Pass {
+			failed++
+		}
+	}
+	if c.flags.json {
+		b, _ := json.MarshalIndent(ordered, "", "  ")
+		fmt.Println(string(b))
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprint(w, "source\tresult\tpastes\ttime\tproblem\n")
+		for _, check := range ordered {
+			result := "pass"
+			if !check.Pass {
+				result = "FAIL"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%dms\t%s\n", check.Source, result, check.Listed, check.Duration, check.Problem)
+		}
+		w.Flush()
+	}
+	if failed > 0 {
+		os.Exit(exitSourcesFailing)
+	}
+}
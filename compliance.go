@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Ways -redact writes addresses
+const (
+	redactHash = "hash"
+	redactMask = "mask"
+)
+
+const (
+	// complianceRateLimit is the least delay between two requests to a
+	// site in compliance mode
+	complianceRateLimit = 5 * time.Second
+	// complianceAgent is the User-Agent sent in compliance mode when none
+	// is configured, so sites can tell who is crawling them
+	complianceAgent = "mailbot (compliance mode)"
+)
+
+// complianceDefaults are the flags -compliance sets when they aren't given
+var complianceDefaults = []struct{ name, value string }{
+	{"robots", "true"},
+	{"redact", redactHash},
+	{"retention", "720h"},
+	{"audit", "mailbot-audit.jsonl"},
+	// Undated plain output couldn't be pruned by -retention
+	{"provenance", "true"},
+}
+
+// applyCompliance sets the flags -compliance implies, leaving those given
+// on the command line or in the config's options as they are
+func (c *Crawler) applyCompliance() error {
+	if !c.flags.compliance {
+		return nil
+	}
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for _, d := range complianceDefaults {
+		if given[d.name] {
+			continue
+		}
+		if err := flag.Set(d.name, d.value); err != nil {
+			return fmt.Errorf("-compliance: %v", err)
+		}
+	}
+	return nil
+}
+
+// conservative makes s crawl its site slowly, one paste at a time, under a
+// User-Agent saying who it is
+func (c *Crawler) conservative(s *Source) {
+	if s.network.RateLimit < complianceRateLimit {
+		s.network.RateLimit = complianceRateLimit
+	}
+	if s.concurrency > 1 {
+		s.concurrency = 1
+	}
+	for k, v := range s.network.Headers {
+		if strings.EqualFold(k, "User-Agent") && v != "" {
+			return
+		}
+	}
+	s.network.Headers["User-Agent"] = complianceAgent
+}
+
+// redact returns mail as -redact has it written
+func (c *Crawler) redact(mail string) string {
+	switch c.flags.redact {
+	case redactHash:
+		return hashMail(mail)
+	case redactMask:
+		return maskMail(mail)
+	}
+	return mail
+}
+
+// hashMail returns the SHA-256 of mail, in lower case. It isn't salted, so
+// that whether a known address was found can still be told.
+func hashMail(mail string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(mail)))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// maskMail keeps the first letter of the local part of mail and its domain
+func maskMail(mail string) string {
+	at := strings.LastIndex(mail, "@")
+	if at < 1 {
+		return "***" + mail[at+1:]
+	}
+	return mail[:1] + "***" + mail[at:]
+}
+
+// redactText redacts the addresses in text, such as the excerpt of a
+// paste, and blanks out the suppressed ones, checked before redacting
+func (c *Crawler) redactText(text string) string {
+	page := []byte(text)
+	var b strings.Builder
+	last := 0
+	for _, m := range scanMails(nil, page) {
+		// m is a sub-slice of page, so its capacity tells where it starts
+		start := cap(page) - cap(m)
+		mail, shown := string(m), c.redact(string(m))
+		if c.isSuppressed(mail) {
+			shown = "[suppressed]"
+		}
+		b.WriteString(text[last:start])
+		b.WriteString(shown)
+		last = start + len(m)
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// shownMail matches an address as redactText leaves it: plain, masked or
+// hashed
+var shownMail = regexp.MustCompile(`[\w*]+@[\w.]+|sha256:[0-9a-f]{64}`)
+
+// shownMails returns the addresses in text as redactText left them
+func shownMails(text string) []string {
+	return shownMail.FindAllString(text, -1)
+}
+
+// storeKey is the key mail is deduplicated by: mail as the output has it,
+// so that no address -redact hides is kept in the store, and those in the
+// output count on restart. Addresses that mask the same count as one.
+func (c *Crawler) storeKey(mail string) string {
+	return c.redact(mail)
+}
+
+// pruneRetention removes the records older than -retention from the files
+// mailbot keeps, every hour, until the crawler stops. Every instance prunes
+// its own files; the leader alone prunes what the fleet shares.
+func (c *Crawler) pruneRetention() {
+	t := time.NewTicker(time.Hour)
+	defer t.Stop()
+	for {
+		c.prune(time.Now().Add(-c.flags.retention))
+		if !c.tick(t) {
+			return
+		}
+	}
+}
+
+// prune removes the records dated before cutoff from the output, the
+// spools, the alerts, the dead letters and the digest, and forgets the
+// addresses pruned from the output, which count as new if found again.
+// Records without a date, like plain addresses written without
+// -provenance, are kept, and so is the audit log, whose chain would break.
+func (c *Crawler) prune(cutoff time.Time) {
+	old := func(line []byte) bool {
+		var r struct {
+			Time        time.Time `json:"time"`
+			LastAttempt time.Time `json:"last_attempt"`
+		}
+		if line[0] != '{' {
+			// Plain output, dated by -provenance
+			r.Time = parsePlain(string(line)).Time
+		} else if json.Unmarshal(line, &r) != nil {
+			return false
+		}
+		if r.LastAttempt.After(r.Time) {
+			r.Time = r.LastAttempt
+		}
+		return !r.Time.IsZero() && r.Time.Before(cutoff)
+	}
+	var (
+		pruned  int
+		expired []Finding
+	)
+	for _, b := range c.sinks {
+		var (
+			n   int
+			err error
+		)
+		if f, ok := b.sink.(*fileSink); ok {
+			n, err = f.scrub(func(line []byte) bool {
+				if !old(line) {
+					return false
+				}
+				expired = append(expired, parsePlain(string(line)))
+				return true
+			})
+		}
+		if err == nil {
+			var m int
+			m, err = b.spool.scrub(old)
+			n += m
+		}
+		if err != nil {
+			report(fmt.Errorf("retention: %v", err))
+		}
+		pruned += n
+	}
+	if c.flags.alerts != "" {
+		c.alerts.mu.Lock()
+		n, err := scrubFile(c.flags.alerts, old)
+		if n > 0 && c.alerts.out != nil {
+			// alert opens the new file
+			c.alerts.out.Close()
+			c.alerts.out = nil
+		}
+		c.alerts.mu.Unlock()
+		if err != nil {
+			report(fmt.Errorf("retention: %v", err))
+		}
+		pruned += n
+	}
+	if c.flags.deadletter != "" {
+		c.mu.Lock()
+		n, err := scrubFile(c.flags.deadletter, old)
+		if n > 0 && c.deadletter != nil {
+			c.deadletter.Close()
+			c.deadletter = nil
+		}
+		c.mu.Unlock()
+		if err != nil {
+			report(fmt.Errorf("retention: %v", err))
+		}
+		pruned += n
+	}
+	for _, f := range expired {
+		if err := c.store.Remove(kindEmail, campaignKey(f.Campaign, f.Email)); err != nil {
+			report(fmt.Errorf("retention: %v", err))
+			break
+		}
+	}
+	if c.isLeader() {
+		// The digest may be the fleet's, shared through Redis
+		pruned += c.pruneDigest(cutoff)
+	}
+	if pruned > 0 {
+		c.logf(levelInfo, "retention: removed %d records from before %s", pruned, cutoff.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestRedactText(t *testing.T) {
+	redact, list := c.flags.redact, c.suppressed.list
+	defer func() { c.flags.redact, c.suppressed.list = redact, list }()
+	c.suppressed.list = &watchlist{addresses: make(map[string]bool)}
+	c.suppressed.list.add("jbob@x.com")
+	c.suppressed.list.add("@hidden.org")
+
+	tests := []struct {
+		redact string
+		text   string
+		want   string
+	}{
+		{"", "mail bob@x.com", "mail bob@x.com"},
+		{"mask", "mail bob@x.com or eve@y.net.", "mail b***@x.com or e***@y.net."},
+		{"mask", "bob@x.com, jbob@x.com", "b***@x.com, [suppressed]"},
+		// A short address inside a longer one is left to the longer one
+		{"mask", "jbob@x.com then bob@x.com", "[suppressed] then b***@x.com"},
+		{"mask", "ann@x.co and ann@x.com", "a***@x.co and a***@x.com"},
+		{"hash", "to bob@x.com", "to " + hashMail("bob@x.com")},
+		{"hash", "jbob@x.com bob@x.com", "[suppressed] " + hashMail("bob@x.com")},
+		{"", "a@hidden.org, b@seen.org", "[suppressed], b@seen.org"},
+		{"", "no address @ all", "no address @ all"},
+	}
+	for _, tt := range tests {
+		c.flags.redact = tt.redact
+		if got := c.redactText(tt.text); got != tt.want {
+			t.Errorf("%s %q: %q, want %q", tt.redact, tt.text, got, tt.want)
+		}
+	}
+}
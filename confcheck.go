@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// schema walks a config document along the type it decodes into, noting
+// where every key is and what is wrong with it
+type schema struct {
+	text     []byte
+	keys     map[string]int64
+	problems []string
+	// where, given an offset into text, formats it as file:line:column
+	where func(int64) string
+}
+
+// problem notes what is wrong at offset off of the document
+func (s *schema) problem(off int64, key, format string, args ...interface{}) {
+	s.problems = append(s.problems, fmt.Sprintf("%s: %s: %s", s.where(off), key, fmt.Sprintf(format, args...)))
+}
+
+// skip returns the offset of the next value or key from off, past the
+// blanks, commas and colons between them
+func (s *schema) skip(off int64) int64 {
+	for off < int64(len(s.text)) && strings.IndexByte(" \t\r\n,:", s.text[off]) >= 0 {
+		off++
+	}
+	return off
+}
+
+// value checks raw, found at offset off under key, against t
+func (s *schema) value(raw json.RawMessage, off int64, key string, t reflect.Type) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct && t.Kind() != reflect.Map {
+		if err := json.Unmarshal(raw, reflect.New(t).Interface()); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				err = fmt.Errorf("want %s, not %s", typeName(t), typeErr.Value)
+			}
+			s.problem(off, key, "%v", err)
+		}
+		return
+	}
+	if len(raw) == 0 || raw[0] != '{' {
+		if string(raw) != "null" {
+			s.problem(off, key, "want an object")
+		}
+		return
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	dec.Token()
+	for dec.More() {
+		keyOff := s.skip(off + dec.InputOffset())
+		tok, err := dec.Token()
+		if err != nil {
+			// Checked by the first pass
+			return
+		}
+		name := tok.(string)
+		path := name
+		if key != "" {
+			path = key + "." + name
+		}
+		s.keys[path] = keyOff
+		valOff := s.skip(off + dec.InputOffset())
+		var child json.RawMessage
+		if err := dec.Decode(&child); err != nil {
+			return
+		}
+		if t.Kind() == reflect.Map {
+			s.value(child, valOff, path, t.Elem())
+			continue
+		}
+		field, known := fieldTypes(t)
+		ft, ok := field[name]
+		if !ok {
+			s.problem(keyOff, path, "unknown key%s", suggest(name, known))
+			continue
+		}
+		s.value(child, valOff, path, ft)
+	}
+}
+
+// typeName names t as the config file writes its values
+func typeName(t reflect.Type) string {
+	switch {
+	case t == reflect.TypeOf(Duration(0)):
+		return "a duration such as \"30s\""
+	case t.Kind() == reflect.String:
+		return "a string"
+	case t.Kind() == reflect.Bool:
+		return "true or false"
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return "a whole number"
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return "a number"
+	}
+	return t.String()
+}
+
+// fieldTypes returns the types of the keys an object decoding into the
+// struct t may have, and their names in order
+func fieldTypes(t reflect.Type) (map[string]reflect.Type, []string) {
+	fields := make(map[string]reflect.Type)
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			embedded, more := fieldTypes(f.Type)
+			for name, ft := range embedded {
+				fields[name] = ft
+			}
+			names = append(names, more...)
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+		names = append(names, name)
+	}
+	return fields, names
+}
+
+// suggest returns ", did you mean x?" for the one of known closest to name,
+// if it is close enough to be a typo of it
+func suggest(name string, known []string) string {
+	best, bestDist := "", 3
+	for _, k := range known {
+		if d := editDistance(strings.ToLower(name), strings.ToLower(k)); d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// checkSchema checks the config file text read from path, comments
+// stripped, against the Config type, and the names it gives sources,
+// sinks and options. It returns where every key is, and every problem
+// found, each with the line and column it is at.
+func checkSchema(path string, text []byte) (map[string]int64, error) {
+	s := &schema{text: text, keys: make(map[string]int64)}
+	s.where = func(off int64) string { return position(path, text, off) }
+	var raw json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(text))
+	if err := dec.Decode(&raw); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, fmt.Errorf("%s: %v", s.where(syntaxErr.Offset), err)
+		}
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	s.value(raw, s.skip(0), "", reflect.TypeOf(Config{}))
+
+	var names []string
+	for _, src := range sources {
+		names = append(names, src.Name)
+	}
+	var flags []string
+	flag.VisitAll(func(f *flag.Flag) { flags = append(flags, f.Name) })
+	for key, off := range s.keys {
+		parts := strings.SplitN(key, ".", 3)
+		if len(parts) != 2 {
+			continue
+		}
+		switch name := parts[1]; parts[0] {
+		case "sources":
+			if lookupSource(name) == nil {
+				s.problem(off, key, "unknown source%s", suggest(name, names))
+			}
+		case "sinks":
+			if !isSinkKind(name) {
+				s.problem(off, key, "unknown sink, want one of %s", strings.Join(sinkKinds, ", "))
+			}
+		case "options":
+			switch {
+			case name == "config":
+				s.problem(off, key, "the config file can't name another")
+			case flag.Lookup(name) == nil:
+				s.problem(off, key, "unknown option%s", suggest(name, flags))
+			}
+		}
+	}
+	if len(s.problems) == 0 {
+		return s.keys, nil
+	}
+	sort.Strings(s.problems)
+	return nil, errors.New(strings.Join(s.problems, "\n"))
+}
+
+// where returns the file:line:column of key in the config file, such as
+// "options.o", or "" if it doesn't set it
+func (c *Config) where(key string) string {
+	off, ok := c.keys[key]
+	if !ok {
+		return ""
+	}
+	return position(c.path, c.text, off)
+}
+
+// position formats offset off of the file at path holding text as
+// path:line:column
+func position(path string, text []byte, off int64) string {
+	line := bytes.Count(text[:off], []byte("\n")) + 1
+	col := off - int64(bytes.LastIndexByte(text[:off], '\n'))
+	return fmt.Sprintf("%s:%d:%d", path, line, col)
+}
+
+// flagName matches the flags an error message names
+var flagName = regexp.MustCompile(`(^|[^\w-])-([a-z][a-z0-9-]*)`)
+
+// explain adds to err where the config file sets the flags it names, as
+// the message only says which they are
+func (c *Config) explain(err error) error {
+	var at []string
+	seen := make(map[string]bool)
+	for _, m := range flagName.FindAllStringSubmatch(err.Error(), -1) {
+		name := m[2]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if where := c.where("options." + name); where != "" {
+			at = append(at, fmt.Sprintf("%s set at %s", name, where))
+		}
+	}
+	if len(at) == 0 {
+		return err
+	}
+	return fmt.Errorf("%v (%s)", err, strings.Join(at, ", "))
+}
+
+// checkModes checks the options of the distributed modes
+func (c *Crawler) checkModes() error {
+	if c.flags.redis != "" && c.flags.leaderTTL < time.Second {
+		return errors.New("-leader-ttl must be at least 1s")
+	}
+	if c.flags.coordinator && (c.flags.grpc == "" || c.flags.redis != "" || c.flags.leaseTTL <= 0) {
+		return errors.New("-coordinator needs -grpc and a positive -lease-ttl, and can't be combined with -redis")
+	}
+	if c.flags.events != "" && c.flags.eventsFD > 0 {
+		return errors.New("-events and -events-fd are mutually exclusive")
+	}
+	return nil
+}
+
+// Probe is the result of connecting to a server the config names
+type Probe struct {
+	Target   string `json:"target"`
+	Address  string `json:"address"`
+	OK       bool   `json:"ok"`
+	Duration int64  `json:"duration_ms"`
+	Problem  string `json:"problem,omitempty"`
+}
+
+// defaultPorts are the ports of the URL schemes the config may use
+var defaultPorts = map[string]string{
+	"http":   "80",
+	"https":  "443",
+	"redis":  "6379",
+	"socks5": "1080",
+}
+
+// hostPort returns the address raw, a URL or a host:port, connects to
+func hostPort(raw string) (string, error) {
+	if !strings.Contains(raw, "://") {
+		if _, _, err := net.SplitHostPort(raw); err != nil {
+			return "", err
+		}
+		return raw, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("no host in %q", raw)
+	}
+	port := u.Port()
+	if port == "" {
+		if port = defaultPorts[u.Scheme]; port == "" {
+			return "", fmt.Errorf("no port in %q", raw)
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// probeTargets lists the servers the flags and config name, other than
+// the sites crawled
+func (c *Crawler) probeTargets() [][2]string {
+	targets := [][2]string{
+		{"sink webhook", c.flags.sinkWebhook},
+		{"elasticsearch", c.flags.elasticsearch},
+		{"webhook", c.flags.webhook},
+		{"smtp", c.flags.smtp},
+		{"redis", c.flags.redis},
+		{"sentry", c.sentryDSN()},
+		{"coordinator", c.flags.coordinatorURL},
+	}
+	proxies := make(map[string]bool)
+	for _, s := range sources {
+		if s.enabled && s.network.Proxy != "" && !proxies[s.network.Proxy] {
+			proxies[s.network.Proxy] = true
+			targets = append(targets, [2]string{s.Name + " proxy", s.network.Proxy})
+		}
+	}
+	return targets
+}
+
+// probe connects to every server the config names, once
+func (c *Crawler) probe() []Probe {
+	var (
+		probes []Probe
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+	)
+	for _, t := range c.probeTargets() {
+		if t[1] == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(target, raw string) {
+			defer wg.Done()
+			p := Probe{Target: target}
+			addr, err := hostPort(raw)
+			if err == nil {
+				p.Address = addr
+				start := time.Now()
+				var conn net.Conn
+				conn, err = net.DialTimeout("tcp", addr, c.network(nil).Timeout)
+				p.Duration = time.Since(start).Milliseconds()
+				if err == nil {
+					conn.Close()
+				}
+			}
+			p.OK = err == nil
+			if err != nil {
+				p.Problem = err.Error()
+			}
+			mu.Lock()
+			probes = append(probes, p)
+			mu.Unlock()
+		}(t[0], t[1])
+	}
+	wg.Wait()
+	sort.Slice(probes, func(i, j int) bool { return probes[i].Target < probes[j].Target })
+	return probes
+}
+
+// ConfigCheck checks the flags and the -config file as a run would at
+// startup, reporting every problem with where in the file it is, and
+// with -probe connects to the servers they name. It exits with
+// exitConfig if anything is wrong.
+func (c *Crawler) ConfigCheck() {
+	var problems []error
+	add := func(err error) {
+		if err != nil {
+			problems = append(problems, c.config.explain(err))
+		}
+	}
+	add(c.setup())
+	add(c.checkModes())
+	if dsn := c.sentryDSN(); dsn != "" {
+		_, err := newSentry(dsn)
+		add(err)
+	}
+	if c.flags.smtp != "" {
+		_, err := c.newSMTPNotifier()
+		add(err)
+	}
+	for name, path := range map[string]string{"-watchlist": c.flags.watchlist, "-suppress": c.flags.suppress, "-disposable": c.flags.disposable} {
+		if path == "" {
+			continue
+		}
+		if _, err := loadWatchlist(path); err != nil && !(name == "-suppress" && os.IsNotExist(err)) {
+			add(fmt.Errorf("%s: %v", name, err))
+		}
+	}
+	if c.flags.keywords != "" {
+		if _, err := loadKeywords(c.flags.keywords); err != nil {
+			add(fmt.Errorf("-keywords: %v", err))
+		}
+	}
+	for _, err := range problems {
+		reportFatal(err)
+	}
+	if len(problems) > 0 {
+		os.Exit(exitConfig)
+	}
+	if !c.flags.probe {
+		c.logf(0, "config ok")
+		return
+	}
+	probes := c.probe()
+	failed := 0
+	for _, p := range probes {
+		if !p.OK {
+			failed++
+		}
+	}
+	if c.flags.json {
+		b, _ := json.MarshalIndent(probes, "", "  ")
+		fmt.Println(string(b))
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprint(w, "target\taddress\tresult\ttime\tproblem\n")
+		for _, p := range probes {
+			result := "ok"
+			if !p.OK {
+				result = "FAIL"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%dms\t%s\n", p.Target, p.Address, result, p.Duration, p.Problem)
+		}
+		w.Flush()
+	}
+	if failed > 0 {
+		os.Exit(exitConfig)
+	}
+	c.logf(0, "config ok")
+}
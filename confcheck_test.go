@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckSchema(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string // the problems, none for a valid file
+	}{
+		{
+			"valid",
+			`{"network": {"timeout": "5s"}, "sources": {"pastebin": {"campaign": "acme"}}, "sinks": {"file": {"batch_size": 10}}, "options": {"quiet": true}}`,
+			nil,
+		},
+		{"empty", `{}`, nil},
+		{"unknown key", `{"intervall": "1m"}`, []string{`c.json:1:2: intervall: unknown key`}},
+		{"unknown source", `{"sources": {"pastebn": {}}}`, []string{`c.json:1:14: sources.pastebn: unknown source, did you mean "pastebin"?`}},
+		{"unknown sink", `{"sinks": {"kafka": {}}}`, []string{`c.json:1:12: sinks.kafka: unknown sink, want one of file, webhook, elasticsearch`}},
+		{"unknown option", `{"options": {"qiet": true}}`, []string{`c.json:1:14: options.qiet: unknown option, did you mean "quiet"?`}},
+		{"config option", `{"options": {"config": "other.json"}}`, []string{`c.json:1:14: options.config: the config file can't name another`}},
+		{"bad duration", "{\n  \"network\": {\"timeout\": \"bad\"}\n}", []string{`c.json:2:26: network.timeout: time: invalid duration "bad"`}},
+		{
+			"several problems",
+			"{\n  \"sources\": {\"slexi\": {}},\n  \"shard\": 2\n}",
+			[]string{`c.json:2:15: sources.slexi: unknown source, did you mean "slexy"?`, `c.json:3:12: shard: want a string, not number`},
+		},
+		{"syntax error", "{\n  \"shard\": \"1/2\",,\n}", []string{`c.json:2:19: invalid character ','`}},
+	}
+	for _, tt := range tests {
+		keys, err := checkSchema("c.json", []byte(tt.text))
+		if tt.want == nil {
+			if err != nil || keys == nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: no problem found", tt.name)
+			continue
+		}
+		got := strings.Split(err.Error(), "\n")
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: problems %q, want %q", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if !strings.HasPrefix(got[i], tt.want[i]) {
+				t.Errorf("%s: problem %q, want %q", tt.name, got[i], tt.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Config is the optional JSON file given with -config
+type Config struct {
+	Network NetworkConfig            `json:"network"`
+	Sources map[string]*SourceConfig `json:"sources"`
+	Shard   *string                  `json:"shard"`
+	ShardBy *string                  `json:"shard_by"`
+	Sinks   map[string]*SinkConfig   `json:"sinks"`
+	Options map[string]interface{}   `json:"options"`
+
+	// The file it was read from, comments blanked out, and where in it
+	// each key is, by path such as "sources.pastebin.timeout"
+	path string
+	text []byte
+	keys map[string]int64
+}
+
+// SinkConfig is the config entry of a single sink
+type SinkConfig struct {
+	BatchSize     *int      `json:"batch_size"`
+	BatchInterval *Duration `json:"batch_interval"`
+}
+
+// SourceConfig is the config entry of a single source
+type SourceConfig struct {
+	NetworkConfig
+	Concurrency  *int      `json:"concurrency"`
+	MaxRequests  *int      `json:"max_requests_per_cycle"`
+	Priority     *int      `json:"priority"`
+	Weight       *int      `json:"weight"`
+	MinInterval  *Duration `json:"min_interval"`
+	MaxInterval  *Duration `json:"max_interval"`
+	Campaign     *string   `json:"campaign"`
+	Since        *string   `json:"since"`
+	Until        *string   `json:"until"`
+	Posted       *string   `json:"posted"`
+	PostedLayout *string   `json:"posted_layout"`
+}
+
+// NetworkConfig overrides the network settings it sets
+type NetworkConfig struct {
+	Timeout      *Duration         `json:"timeout"`
+	Proxy        *string           `json:"proxy"`
+	RateLimit    *Duration         `json:"rate_limit"`
+	Jitter       *float64          `json:"jitter"`
+	Headers      map[string]string `json:"headers"`
+	Retries      *int              `json:"retries"`
+	RetryBackoff *Duration         `json:"retry_backoff"`
+	BindAddress  *string           `json:"bind_address"`
+}
+
+// apply overrides the fields of n that o sets
+func (o *NetworkConfig) apply(n *Network) {
+	if o.Timeout != nil {
+		n.Timeout = time.Duration(*o.Timeout)
+	}
+	if o.Proxy != nil {
+		n.Proxy = *o.Proxy
+	}
+	if o.RateLimit != nil {
+		n.RateLimit = time.Duration(*o.RateLimit)
+	}
+	if o.Jitter != nil {
+		n.Jitter = *o.Jitter
+	}
+	for k, v := range o.Headers {
+		n.Headers[k] = v
+	}
+	if o.Retries != nil {
+		n.Retries = *o.Retries
+	}
+	if o.RetryBackoff != nil {
+		n.RetryBackoff = time.Duration(*o.RetryBackoff)
+	}
+	if o.BindAddress != nil {
+		n.BindAddress = *o.BindAddress
+	}
+}
+
+// Duration is a time.Duration written as a string such as "1m30s"
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON formats the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// loadConfig reads the config file at path, if any. It is JSON in which
+// "//" starts a comment running to the end of the line.
+func (c *Crawler) loadConfig(path string) error {
+	if path == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	text := stripComments(b)
+	// First for the line and column of every problem, which the decoder
+	// doesn't tell
+	keys, err := checkSchema(path, text)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(text))
+	dec.DisallowUnknownFields()
+	dec.UseNumber()
+	if err := dec.Decode(&c.config); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	c.config.path, c.config.text, c.config.keys = path, text, keys
+	return c.applyOptions(path)
+}
+
+// applyOptions sets the flags named in the config's "options" that weren't
+// given on the command line
+func (c *Crawler) applyOptions(path string) error {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for name, value := range c.config.Options {
+		if flag.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("%s: unknown option %q", path, name)
+		}
+		if given[name] {
+			continue
+		}
+		if err := flag.Set(name, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("%s: options.%s: invalid value %q: %v", c.config.where("options."+name), name, fmt.Sprint(value), err)
+		}
+	}
+	return nil
+}
+
+// isSet reports whether the flag name was given, on the command line or in
+// the config's "options"
+func isSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) { set = set || f.Name == name })
+	return set
+}
+
+// stripComments blanks out the "//" comments outside of strings in a JSON
+// document, keeping every line and column where it was
+func stripComments(b []byte) []byte {
+	out := append([]byte(nil), b...)
+	inString, escaped := false, false
+	for i := 0; i < len(out); i++ {
+		switch {
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case out[i] == '\\':
+				escaped = true
+			case out[i] == '"':
+				inString = false
+			}
+		case out[i] == '"':
+			inString = true
+		case out[i] == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		}
+	}
+	return out
+}
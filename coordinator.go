@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// With -coordinator the crawling instance leases the pastes it lists to
+// workers over the Coordinator gRPC service. Workers only fetch and
+// extract; deduplication and every sink stay with the coordinator.
+
+const (
+	leaseBacklog = 10000
+	leasePoll    = 5 * time.Second
+	leaseMax     = 100
+)
+
+// lease is a job handed to a worker until its deadline
+type lease struct {
+	job      job
+	worker   string
+	deadline time.Time
+}
+
+// leaseQueue is the coordinator's jobQueue. Jobs not completed before
+// their lease expires go back to the queue.
+type leaseQueue struct {
+	pending chan job
+	ttl     time.Duration
+
+	mu     sync.Mutex
+	seq    int64
+	active map[string]*lease
+}
+
+func (q *leaseQueue) Push(j job) error {
+	select {
+	case q.pending <- j:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+func (q *leaseQueue) Pop(timeout time.Duration) (job, bool, error) {
+	if timeout <= 0 {
+		select {
+		case j := <-q.pending:
+			return j, true, nil
+		default:
+			return job{}, false, nil
+		}
+	}
+	select {
+	case j := <-q.pending:
+		return j, true, nil
+	case <-time.After(timeout):
+		return job{}, false, nil
+	}
+}
+
+// grant leases j to worker and returns the lease id
+func (q *leaseQueue) grant(j job, worker string) string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.seq++
+	id := strconv.FormatInt(q.seq, 36)
+	q.active[id] = &lease{j, worker, time.Now().Add(q.ttl)}
+	return id
+}
+
+// startCoordinator hands the listed pastes to workers from now on
+func (c *Crawler) startCoordinator() {
+	q := &leaseQueue{
+		pending: make(chan job, leaseBacklog),
+		ttl:     c.flags.leaseTTL,
+		active:  make(map[string]*lease),
+	}
+	c.leases = q
+	c.jobs = q
+	c.spawn("lease expiry", func() {
+		t := time.NewTicker(q.ttl / 2)
+		defer t.Stop()
+		for c.tick(t) {
+			c.expireLeases()
+		}
+	})
+}
+
+// expireLeases queues the jobs of expired leases again
+func (c *Crawler) expireLeases() {
+	q := c.leases
+	now := time.Now()
+	var expired []*lease
+	q.mu.Lock()
+	for id, l := range q.active {
+		if now.After(l.deadline) {
+			expired = append(expired, l)
+			delete(q.active, id)
+		}
+	}
+	q.mu.Unlock()
+	for _, l := range expired {
+		c.logf(levelInfo, "lease of %s by %s expired", l.job.URL, l.worker)
+		if err := q.Push(l.job); err != nil {
+			key := l.job.URL
+			if s := lookupSource(l.job.Source); s != nil {
+				key = s.pasteKey(key)
+			}
+			c.store.Remove(kindPaste, key)
+		}
+	}
+}
+
+func (c *Crawler) grpcLease(req protoFields) ([]byte, error) {
+	worker := req.string(1)
+	if worker == "" {
+		return nil, grpcErrorf(grpcInvalidArgument, "worker is required")
+	}
+	max := int(req.int64(2))
+	if max <= 0 || max > leaseMax {
+		max = leaseMax
+	}
+	var reply []byte
+	// Wait for the first job only, so idle workers poll slowly
+	timeout := leasePoll
+	for n := 0; n < max; n++ {
+		j, ok, _ := c.leases.Pop(timeout)
+		if !ok {
+			break
+		}
+		timeout = 0
+		var m []byte
+		m = appendString(m, 1, c.leases.grant(j, worker))
+		m = appendString(m, 2, j.Source)
+		m = appendString(m, 3, j.URL)
+		reply = appendMessage(reply, 1, m)
+	}
+	reply = appendInt64(reply, 2, c.leases.ttl.Milliseconds())
+	return reply, nil
+}
+
+func (c *Crawler) grpcHeartbeat(req protoFields) ([]byte, error) {
+	q := c.leases
+	worker := req.string(1)
+	deadline := time.Now().Add(q.ttl)
+	extended := 0
+	q.mu.Lock()
+	for _, id := range req.all[2] {
+		if l := q.active[string(id)]; l != nil && l.worker == worker {
+			l.deadline = deadline
+			extended++
+		}
+	}
+	q.mu.Unlock()
+	return encodeAck(fmt.Sprintf("%d leases extended", extended)), nil
+}
+
+func (c *Crawler) grpcComplete(req protoFields) ([]byte, error) {
+	q := c.leases
+	id := req.string(1)
+	q.mu.Lock()
+	l := q.active[id]
+	delete(q.active, id)
+	q.mu.Unlock()
+	if l == nil {
+		return nil, grpcErrorf(grpcNotFound, "unknown or expired lease %q", id)
+	}
+	s := lookupSource(l.job.Source)
+	if msg := req.string(3); msg != "" {
+		err := fmt.Errorf("%s: %s", l.worker, msg)
+		report(err)
+		c.sourceError(s, l.job.URL, err)
+		return encodeAck("failure recorded"), nil
+	}
+	var mails []string
+	for _, m := range req.all[2] {
+		mails = append(mails, string(m))
+	}
+	c.count(metricPastes, s.Name, 1)
+	c.collect(s, l.job.URL, req.string(4), mails)
+	return encodeAck("completed"), nil
+}
+
+// grpcClient calls the Coordinator service over cleartext HTTP/2
+type grpcClient struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+func newGRPCClient(base, token string) *grpcClient {
+	t := &http.Transport{Protocols: new(http.Protocols)}
+	t.Protocols.SetUnencryptedHTTP2(true)
+	return &grpcClient{strings.TrimSuffix(base, "/"), token, &http.Client{Transport: t}}
+}
+
+// call sends a unary RPC and decodes its reply
+func (g *grpcClient) call(method string, body []byte) (protoFields, error) {
+	frame := make([]byte, 5, 5+len(body))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(body)))
+	req, err := http.NewRequestWithContext(c.ctx, "POST", g.base+method, bytes.NewReader(append(frame, body...)))
+	if err != nil {
+		return protoFields{}, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	req.Header.Set("Authorization", "Bearer "+g.token)
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return protoFields{}, err
+	}
+	defer resp.Body.Close()
+	reply, readErr := readGRPCMessage(resp.Body)
+	io.Copy(ioutil.Discard, resp.Body)
+	// Errors may come as trailers-only responses, in the headers
+	status, msg := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, msg = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		if m, err := url.PathUnescape(msg); err == nil {
+			msg = m
+		}
+		code, _ := strconv.Atoi(status)
+		return protoFields{}, &grpcError{code, fmt.Sprintf("%s: %s", method, msg)}
+	}
+	if readErr != nil {
+		return protoFields{}, readErr
+	}
+	return decodeProto(reply)
+}
+
+// workLeases fetches and extracts the pastes leased from the coordinator
+// at base, -concurrency at a time, until it is stopped
+func (c *Crawler) workLeases(base string) {
+	token, err := c.apiToken()
+	if err != nil {
+		reportFatal(err)
+		os.Exit(exitConfig)
+	}
+	g := newGRPCClient(base, token)
+	host, _ := os.Hostname()
+	worker := fmt.Sprintf("%s-%d", host, os.Getpid())
+
+	var (
+		mu     sync.Mutex
+		held   = make(map[string]bool)
+		period = time.Second
+		slots  = make(chan struct{}, c.flags.concurrency)
+	)
+	c.spawn("heartbeat", func() {
+		for {
+			mu.Lock()
+			wait := period
+			var m []byte
+			m = appendString(m, 1, worker)
+			for id := range held {
+				m = appendString(m, 2, id)
+			}
+			mu.Unlock()
+			if !c.sleep(wait) {
+				return
+			}
+			if _, err := g.call("/mailbot.Coordinator/Heartbeat", m); err != nil {
+				c.logf(levelInfo, "heartbeat: %v", err)
+			}
+		}
+	})
+	for c.ctx.Err() == nil {
+		// Wait for a free slot, then lease as many jobs as slots are free
+		slots <- struct{}{}
+		free := 1 + c.flags.concurrency - len(slots)
+		var m []byte
+		m = appendString(m, 1, worker)
+		m = appendInt64(m, 2, int64(free))
+		reply, err := g.call("/mailbot.Coordinator/Lease", m)
+		if err != nil {
+			<-slots
+			if c.ctx.Err() == nil {
+				report(err)
+				c.sleep(time.Second)
+			}
+			continue
+		}
+		if ttl := time.Duration(reply.int64(2)) * time.Millisecond; ttl > 0 {
+			mu.Lock()
+			period = ttl / 3
+			mu.Unlock()
+		}
+		leases := reply.all[1]
+		if len(leases) == 0 {
+			<-slots
+			continue
+		}
+		for i, raw := range leases {
+			// The first of them took the slot waited for above
+			if i > 0 {
+				slots <- struct{}{}
+			}
+			l, err := decodeProto(raw)
+			if err != nil {
+				<-slots
+				report(err)
+				continue
+			}
+			id := l.string(1)
+			mu.Lock()
+			held[id] = true
+			mu.Unlock()
+			id, j := id, job{l.string(2), l.string(3)}
+			c.spawn("lease "+j.URL, func() {
+				defer func() {
+					mu.Lock()
+					delete(held, id)
+					mu.Unlock()
+					<-slots
+				}()
+				if err := c.complete(g, id, j); err != nil {
+					report(err)
+				}
+			})
+		}
+	}
+}
+
+// complete fetches the paste of a leased job and returns what it holds
+func (c *Crawler) complete(g *grpcClient, id string, j job) error {
+	s := lookupSource(j.Source)
+	if s == nil {
+		return fmt.Errorf("lease for unknown source %q", j.Source)
+	}
+	defer c.capturePanic(s, j.URL)
+	var m []byte
+	m = appendString(m, 1, id)
+	page, err := c.FetchPage(s, j.URL)
+	if err != nil {
+		m = appendString(m, 3, err.Error())
+	} else {
+		c.count(metricPastes, s.Name, 1)
+		// Only the worker sees the page, so it scans for the keywords
+		c.scanKeywords(s, j.URL, page)
+		for _, mail := range extract(j.URL, page) {
+			m = appendString(m, 2, mail)
+		}
+		m = appendString(m, 4, hashPage(page))
+	}
+	_, err = g.call("/mailbot.Coordinator/Complete", m)
+	var ge *grpcError
+	if errors.As(err, &ge) && ge.code == grpcNotFound {
+		return fmt.Errorf("%s: lease expired, result dropped", j.URL)
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// leaseRequest returns the request build encodes as a handler receives it
+func leaseRequest(t *testing.T, build func(m []byte) []byte) protoFields {
+	req, err := decodeProto(build(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestLeases(t *testing.T) {
+	setupTest(t)
+	leases := c.leases
+	defer func() { c.leases = leases }()
+	q := &leaseQueue{pending: make(chan job, 10), ttl: time.Minute, active: make(map[string]*lease)}
+	c.leases = q
+	for _, id := range []string{"a", "b", "c"} {
+		q.Push(job{"pastebin", "https://pastebin.com/raw/" + id})
+	}
+
+	if _, err := c.grpcLease(leaseRequest(t, func(m []byte) []byte { return appendInt64(m, 2, 1) })); !isGRPCCode(err, grpcInvalidArgument) {
+		t.Errorf("lease without a worker: %v", err)
+	}
+	reply, err := c.grpcLease(leaseRequest(t, func(m []byte) []byte {
+		return appendInt64(appendString(m, 1, "w1"), 2, 2)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := decodeProto(reply)
+	var ids []string
+	for _, raw := range r.all[1] {
+		l, _ := decodeProto(raw)
+		ids = append(ids, l.string(1))
+		if l.string(2) != "pastebin" || l.string(3) == "" {
+			t.Errorf("leased %q of %q", l.string(3), l.string(2))
+		}
+	}
+	if len(ids) != 2 || r.int64(2) != time.Minute.Milliseconds() {
+		t.Fatalf("leased %d jobs for %dms, want 2 for 60000ms", len(ids), r.int64(2))
+	}
+	if len(q.pending) != 1 {
+		t.Errorf("%d jobs left, want 1", len(q.pending))
+	}
+
+	heartbeat := func(worker string) string {
+		ack, err := c.grpcHeartbeat(leaseRequest(t, func(m []byte) []byte {
+			m = appendString(m, 1, worker)
+			for _, id := range ids {
+				m = appendString(m, 2, id)
+			}
+			return m
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, _ := decodeProto(ack)
+		return f.string(1)
+	}
+	if got := heartbeat("w2"); got != "0 leases extended" {
+		t.Errorf("other worker's heartbeat: %q", got)
+	}
+	if got := heartbeat("w1"); got != "2 leases extended" {
+		t.Errorf("heartbeat: %q", got)
+	}
+
+	// A lease not renewed in time goes back to the queue
+	q.active[ids[0]].deadline = time.Now().Add(-time.Second)
+	c.expireLeases()
+	if len(q.active) != 1 || len(q.pending) != 2 {
+		t.Errorf("after expiry %d leases and %d jobs, want 1 and 2", len(q.active), len(q.pending))
+	}
+	_, err = c.grpcComplete(leaseRequest(t, func(m []byte) []byte { return appendString(m, 1, ids[0]) }))
+	if !isGRPCCode(err, grpcNotFound) {
+		t.Errorf("completing an expired lease: %v", err)
+	}
+}
+
+func isGRPCCode(err error, code int) bool {
+	var ge *grpcError
+	return errors.As(err, &ge) && ge.code == code
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// DeadLetter is a URL that could not be fetched after all retries
+type DeadLetter struct {
+	Source       string    `json:"source"`
+	URL          string    `json:"url"`
+	Reason       string    `json:"reason"`
+	Attempts     int       `json:"attempts"`
+	FirstAttempt time.Time `json:"first_attempt"`
+	LastAttempt  time.Time `json:"last_attempt"`
+}
+
+// DeadLetter appends a permanently failed URL to the dead-letter file
+func (c *Crawler) DeadLetter(s *Source, url string, reason error, first time.Time, attempts int) {
+	if c.flags.deadletter == "" {
+		return
+	}
+	c.writeDeadLetter(DeadLetter{
+		Source:       s.Name,
+		URL:          url,
+		Reason:       reason.Error(),
+		Attempts:     attempts,
+		FirstAttempt: first,
+		LastAttempt:  time.Now(),
+	})
+}
+
+// writeDeadLetter appends l to the dead-letter file, counting it as lost
+// if it can't
+func (c *Crawler) writeDeadLetter(l DeadLetter) {
+	b, err := json.Marshal(l)
+	if err != nil {
+		report(err)
+		atomic.AddInt64(&c.deadLost, 1)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.deadletter == nil {
+		c.deadletter, err = os.OpenFile(
+			c.flags.deadletter,
+			os.O_APPEND|os.O_WRONLY|os.O_CREATE,
+			0600,
+		)
+		if err != nil {
+			report(err)
+			atomic.AddInt64(&c.deadLost, 1)
+			return
+		}
+	}
+	if err := appendLines(c.deadletter, append(b, '\n')); err != nil {
+		report(err)
+		atomic.AddInt64(&c.deadLost, 1)
+	}
+}
+
+// takeDeadLetters moves the dead-letter file to pending for a retry. The
+// dead letters a retry that was cut short left in pending are kept, and the
+// new ones appended to them. Without either file there is nothing to
+// retry, and pending isn't created.
+func takeDeadLetters(path, pending string) error {
+	if _, err := os.Stat(pending); os.IsNotExist(err) {
+		if err := os.Rename(path, pending); !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := recoverLines(pending); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(pending, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err := appendLines(f, data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Retry re-fetches every URL in the dead-letter file and collects emails
+// from those that now succeed. Every URL that doesn't, whatever the reason,
+// is dead-lettered anew; the file being retried is only removed once all
+// of them are.
+func (c *Crawler) Retry() {
+	pending := c.flags.deadletter + ".retrying"
+	if err := takeDeadLetters(c.flags.deadletter, pending); err != nil {
+		report(err)
+		return
+	}
+	data, err := ioutil.ReadFile(pending)
+	if os.IsNotExist(err) {
+		c.logf(0, "nothing to retry in %s", c.flags.deadletter)
+		c.shutdown("retry done", exitOK)
+		return
+	}
+	if err != nil {
+		report(err)
+		return
+	}
+
+	seen := make(map[string]bool)
+	var letters []DeadLetter
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var l DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			report(fmt.Errorf("%s: %v", pending, err))
+			continue
+		}
+		if seen[l.URL] {
+			continue
+		}
+		seen[l.URL] = true
+		letters = append(letters, l)
+	}
+
+	var recovered, done int64
+	stop := c.progress(func() string {
+		return fmt.Sprintf("retrying %d/%d urls, %d recovered",
+			atomic.LoadInt64(&done), len(letters), atomic.LoadInt64(&recovered))
+	})
+	for _, l := range letters {
+		atomic.AddInt64(&done, 1)
+		if c.ctx.Err() != nil {
+			// Stopped before its turn
+			c.writeDeadLetter(l)
+			continue
+		}
+		s := lookupSource(l.Source)
+		if s == nil {
+			report(fmt.Errorf("%s: unknown source %q", l.URL, l.Source))
+			c.writeDeadLetter(l)
+			continue
+		}
+		page, err := c.FetchPage(s, l.URL)
+		if err != nil {
+			report(err)
+			if err == errBudget || err == errRobots || c.ctx.Err() != nil {
+				// FetchPage only dead-letters the URL's own failures
+				c.writeDeadLetter(l)
+			}
+			continue
+		}
+		atomic.AddInt64(&recovered, 1)
+		c.count(metricPastes, s.Name, 1)
+		c.GetMail(s, l.URL, page)
+	}
+	c.drain()
+	stop()
+	c.logf(0, "retried %d urls, %d recovered", len(letters), recovered)
+	if lost := atomic.LoadInt64(&c.deadLost); lost > 0 {
+		reportFatal(fmt.Errorf("%d urls couldn't be dead-lettered again, %s is kept for the next retry", lost, pending))
+		c.shutdown("retry done", exitSink)
+		return
+	}
+	os.Remove(pending)
+	c.shutdown("retry done", exitOK)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTakeDeadLetters(t *testing.T) {
+	tests := []struct {
+		name     string
+		letters  string // "" for no dead-letter file
+		retrying string // "" for no .retrying file
+		want     string // "" for no .retrying file after
+	}{
+		{"nothing to retry", "", "", ""},
+		{"new letters", "a\n", "", "a\n"},
+		{"leftover retry", "", "b\n", "b\n"},
+		{"both", "a\n", "b\n", "b\na\n"},
+		{"torn leftover", "a\n", "b\n{\"tor", "b\na\n"},
+	}
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "mailbot")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "deadletter.jsonl")
+		pending := path + ".retrying"
+		if tt.letters != "" {
+			ioutil.WriteFile(path, []byte(tt.letters), 0600)
+		}
+		if tt.retrying != "" {
+			ioutil.WriteFile(pending, []byte(tt.retrying), 0600)
+		}
+		if err := takeDeadLetters(path, pending); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s: %s left behind", tt.name, path)
+		}
+		got, err := ioutil.ReadFile(pending)
+		switch {
+		case tt.want == "" && !os.IsNotExist(err):
+			t.Errorf("%s: %s created", tt.name, pending)
+		case tt.want != "" && string(got) != tt.want:
+			t.Errorf("%s: %s holds %q, want %q", tt.name, pending, got, tt.want)
+		}
+	}
+}
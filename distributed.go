@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// job is a raw paste to fetch and scan, handed from the instance listing
+// the archives to the workers
+type job struct {
+	Source string `json:"source"`
+	URL    string `json:"url"`
+}
+
+// jobQueue carries jobs between instances
+type jobQueue interface {
+	// Push adds j to the queue
+	Push(j job) error
+	// Pop waits up to timeout for a job and reports whether it got one
+	Pop(timeout time.Duration) (job, bool, error)
+}
+
+// redisQueue is a jobQueue kept in a Redis list
+type redisQueue struct {
+	client *redisClient
+	key    string
+}
+
+func (q *redisQueue) Push(j job) error {
+	b, _ := json.Marshal(j)
+	_, err := q.client.do("LPUSH", q.key, string(b))
+	return err
+}
+
+func (q *redisQueue) Pop(timeout time.Duration) (job, bool, error) {
+	reply, err := q.client.do("BRPOP", q.key, fmt.Sprint(int(timeout.Seconds())))
+	if err != nil || reply == nil {
+		return job{}, false, err
+	}
+	parts, ok := reply.([]interface{})
+	if !ok || len(parts) != 2 {
+		return job{}, false, fmt.Errorf("redis: unexpected BRPOP reply")
+	}
+	var j job
+	raw, _ := parts[1].(string)
+	if err := json.Unmarshal([]byte(raw), &j); err != nil {
+		return job{}, false, fmt.Errorf("bad job %q: %v", raw, err)
+	}
+	return j, true, nil
+}
+
+// setupRedis shares the store through -redis, and the work queue with
+// -dispatch and to workers
+func (c *Crawler) setupRedis() error {
+	client, err := newRedisClient(c.flags.redis)
+	if err != nil {
+		return err
+	}
+	if _, err := client.do("PING"); err != nil {
+		return fmt.Errorf("redis: %v", err)
+	}
+	c.redis = client
+	if c.flags.dispatch || c.command == "worker" {
+		c.jobs = &redisQueue{client, c.flags.redisPrefix + "jobs"}
+	}
+	return nil
+}
+
+// newStore returns the store shared through -redis, or an in-memory one
+func (c *Crawler) newStore() Store {
+	if c.redis != nil {
+		return &redisStore{c.redis, c.flags.redisPrefix}
+	}
+	return newMemoryStore()
+}
+
+// dispatch hands the paste at url to the workers
+func (c *Crawler) dispatch(s *Source, url string) {
+	if err := c.jobs.Push(job{s.Name, url}); err != nil {
+		report(err)
+		// Let the next cycle try again
+		c.store.Remove(kindPaste, s.pasteKey(url))
+		return
+	}
+	c.count(metricQueued, s.Name, 1)
+}
+
+// Work fetches and scans the jobs queued by the crawling instances until
+// it is stopped. Request budgets are per cycle and so don't apply.
+func (c *Crawler) Work() {
+	if c.jobs == nil && c.flags.coordinatorURL == "" {
+		reportFatal(fmt.Errorf("worker needs -redis or -coordinator-url"))
+		os.Exit(exitConfig)
+	}
+	c.flags.maxRequests = 0
+	for _, s := range sources {
+		s.maxRequests = 0
+	}
+	c.handleSignals()
+	if c.flags.maxRuntime > 0 {
+		time.AfterFunc(c.flags.maxRuntime, func() {
+			c.shutdown("max runtime reached", exitOK)
+		})
+	}
+	if c.flags.summary > 0 {
+		c.spawn("summary", func() { c.summarize(c.flags.summary, c.flags.summaryFile) })
+	}
+	if c.flags.prometheus != "" {
+		c.servePrometheus(c.flags.prometheus)
+	}
+	if c.flags.coordinatorURL != "" {
+		c.workLeases(c.flags.coordinatorURL)
+	} else {
+		for i := 0; i < c.flags.concurrency; i++ {
+			c.spawn("worker", func() {
+				for c.ctx.Err() == nil {
+					j, ok, err := c.jobs.Pop(5 * time.Second)
+					if err != nil {
+						report(err)
+						c.sleep(time.Second)
+						continue
+					}
+					if ok {
+						c.work(j)
+					}
+				}
+			})
+		}
+	}
+	// shutdown exits once the other goroutines are done
+	select {}
+}
+
+// work fetches and scans the paste of one job
+func (c *Crawler) work(j job) {
+	s := lookupSource(j.Source)
+	if s == nil {
+		report(fmt.Errorf("job for unknown source %q", j.Source))
+		return
+	}
+	defer c.capturePanic(s, j.URL)
+	page, err := c.FetchPage(s, j.URL)
+	if err != nil {
+		report(err)
+		c.sourceError(s, j.URL, err)
+		return
+	}
+	c.count(metricPastes, s.Name, 1)
+	c.GetMail(s, j.URL, page)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// driftExcerpt is how much of an archive page that no longer parses goes
+// into its alert
+const driftExcerpt = 300
+
+// checkDrift raises an alert when the Link pattern of a source that listed
+// pastes before matches nothing on -drift-cycles archive pages in a row.
+// A site with nothing new posted still lists its older pastes, so an
+// archive page that is fetched fine but lists none means its markup
+// changed.
+func (c *Crawler) checkDrift(s *Source, page []byte, links int) {
+	if links > 0 {
+		if s.unlisted >= c.flags.driftCycles && s.everListed {
+			c.logf(0, "%s: archive lists pastes again, %d links", s.Name, links)
+			c.emit(eventSourceDrift, map[string]interface{}{"source": s.Name, "recovered": true, "links": links})
+		}
+		s.everListed, s.unlisted = true, 0
+		return
+	}
+	s.unlisted++
+	if c.flags.driftCycles <= 0 || s.unlisted != c.flags.driftCycles || !s.everListed {
+		return
+	}
+	excerpt := string(page)
+	if len(excerpt) > driftExcerpt {
+		cut := driftExcerpt
+		for cut > 0 && !utf8.RuneStart(excerpt[cut]) {
+			cut--
+		}
+		excerpt = excerpt[:cut]
+	}
+	excerpt = c.redactText(strings.Join(strings.Fields(excerpt), " "))
+	report(fmt.Errorf("%s: no paste links on %d archive pages in a row, its layout may have changed", s.Name, s.unlisted))
+	c.emit(eventSourceDrift, map[string]interface{}{"source": s.Name, "cycles": s.unlisted, "bytes": len(page)})
+	c.alert(Alert{
+		Type:     "drift",
+		Match:    s.Link.String(),
+		Excerpt:  excerpt,
+		Source:   s.Name,
+		URL:      s.Archive,
+		Time:     time.Now(),
+		Campaign: s.campaign,
+	},
+		fmt.Sprintf("mailbot alert: %s parser broken", s.Name),
+		fmt.Sprintf("%s was fetched fine (%d bytes) on the last %d cycles, but the pattern\n%s\nmatched no paste link, though it did before. As a site with nothing new\nstill lists its older pastes, its markup has most likely changed. The page starts with:\n\n%s\n",
+			s.Archive, len(page), s.unlisted, s.Link, excerpt),
+	)
+}
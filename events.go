@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Operational events written to the -events stream
+const (
+	eventCycleStart  = "cycle_start"
+	eventSourceError = "source_error"
+	eventThrottled   = "throttled"
+	eventSinkFlush   = "sink_flush"
+	eventSinkSpool   = "sink_spool"
+	eventShutdown    = "shutdown"
+	eventLeader      = "leader"
+	eventSourceDrift = "source_drift"
+	eventSuppressed  = "suppressed"
+)
+
+// events writes operational events as NDJSON
+type events struct {
+	mu  sync.Mutex
+	out *os.File
+}
+
+// openEvents opens the -events file or -events-fd descriptor, if any
+func (c *Crawler) openEvents() error {
+	switch {
+	case c.flags.events != "":
+		f, err := os.OpenFile(c.flags.events, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		c.events.out = f
+	case c.flags.eventsFD > 0:
+		c.events.out = os.NewFile(uintptr(c.flags.eventsFD), "events")
+	}
+	return nil
+}
+
+// emit writes an event with the given fields, if an event stream is open
+func (c *Crawler) emit(event string, fields map[string]interface{}) {
+	e := &c.events
+	if e.out == nil {
+		return
+	}
+	line := map[string]interface{}{
+		"event": event,
+		"time":  time.Now().Format(time.RFC3339Nano),
+	}
+	for k, v := range fields {
+		line[k] = v
+	}
+	b, err := json.Marshal(line)
+	if err != nil {
+		report(err)
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.out.Write(append(b, '\n'))
+}
+
+// handleSignals shuts the crawler down on SIGINT and SIGTERM
+func (c *Crawler) handleSignals() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	// Not tracked: it runs the shutdown waiting for the others
+	go func() {
+		s := <-sig
+		c.shutdown(s.String(), 0)
+	}()
+}
+
+// shutdown stops every goroutine, giving them -shutdown-timeout to finish
+// their work and reporting those that don't, flushes the output, leaves the
+// terminal as it found it and exits with code. Only the first call does;
+// later ones wait for it.
+func (c *Crawler) shutdown(reason string, code int) {
+	c.stopping.Do(func() { c.stop(reason, code) })
+}
+
+func (c *Crawler) stop(reason string, code int) {
+	c.cancel()
+	fields := map[string]interface{}{"reason": reason, "exit_code": code}
+	if left := c.goroutines.wait(c.flags.shutdownWait); len(left) > 0 {
+		var names []string
+		for _, g := range left {
+			report(fmt.Errorf("shutdown: %s still running after %s", g.name, time.Since(g.started).Round(time.Millisecond)))
+			names = append(names, g.name)
+		}
+		fields["stragglers"] = names
+	}
+	c.emit(eventShutdown, fields)
+	c.resign()
+	c.closeSinks()
+	c.finalSummary()
+	if c.selftest != nil {
+		c.selftest.report()
+	}
+	if c.tui != nil {
+		c.tui.restore()
+		fmt.Print("\x1b[?25h\n")
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// unknown fills in the provenance a record doesn't have
+const unknown = "unknown"
+
+// exportFormats are the formats mailbot export writes
+var exportFormats = []string{"jsonl", "csv", "sqlite", "sinks"}
+
+// toFinding fills in the provenance r lacks as unknown
+func toFinding(r *statsRecord) Finding {
+	f := Finding{Email: r.Email, Source: r.Source, URL: r.URL, Time: r.Time, Campaign: r.Campaign}
+	if f.Source == "" {
+		f.Source = unknown
+	}
+	if f.URL == "" {
+		f.URL = unknown
+	}
+	return f
+}
+
+// sqlQuote quotes s as an SQL string literal
+func sqlQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// Export converts the findings in old output files, plain address logs
+// included, to -format on stdout, or sends them to the configured sinks.
+// Addresses are written once.
+func (c *Crawler) Export(paths []string) {
+	if len(paths) == 0 {
+		reportFatal(errors.New("usage: mailbot export [flags] file..."))
+		os.Exit(exitConfig)
+	}
+	seen := make(map[string]*statsRecord)
+	var found []*statsRecord
+	for _, path := range paths {
+		if _, err := readStats(path, c.flags.campaign, seen, &found); err != nil {
+			reportFatal(err)
+			os.Exit(exitConfig)
+		}
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	switch c.flags.exportFormat {
+	case "jsonl":
+		for _, r := range found {
+			b, _ := json.Marshal(toFinding(r))
+			out.Write(append(b, '\n'))
+		}
+	case "csv":
+		w := csv.NewWriter(out)
+		w.Write([]string{"email", "source", "url", "time", "campaign"})
+		for _, r := range found {
+			f := toFinding(r)
+			var t string
+			if !f.Time.IsZero() {
+				t = f.Time.Format(time.RFC3339Nano)
+			}
+			w.Write([]string{f.Email, f.Source, f.URL, t, f.Campaign})
+		}
+		w.Flush()
+	case "sqlite":
+		fmt.Fprint(out, "CREATE TABLE IF NOT EXISTS findings (email TEXT PRIMARY KEY, source TEXT NOT NULL, url TEXT NOT NULL, time TEXT, campaign TEXT);\nBEGIN;\n")
+		for _, r := range found {
+			f := toFinding(r)
+			t := "NULL"
+			if !f.Time.IsZero() {
+				t = sqlQuote(f.Time.Format(time.RFC3339Nano))
+			}
+			campaign := "NULL"
+			if f.Campaign != "" {
+				campaign = sqlQuote(f.Campaign)
+			}
+			fmt.Fprintf(out, "INSERT OR IGNORE INTO findings VALUES (%s, %s, %s, %s, %s);\n", sqlQuote(f.Email), sqlQuote(f.Source), sqlQuote(f.URL), t, campaign)
+		}
+		fmt.Fprint(out, "COMMIT;\n")
+	case "sinks":
+		sinks := c.remoteSinks()
+		if len(sinks) == 0 {
+			reportFatal(errors.New("-format sinks needs -sink-webhook or -elasticsearch"))
+			os.Exit(exitConfig)
+		}
+		for _, s := range sinks {
+			for i := 0; i < len(found); i += c.flags.batchSize {
+				end := i + c.flags.batchSize
+				if end > len(found) {
+					end = len(found)
+				}
+				batch := make([]Finding, 0, end-i)
+				for _, r := range found[i:end] {
+					batch = append(batch, toFinding(r))
+				}
+				if err := s.Write(batch); err != nil {
+					reportFatal(fmt.Errorf("%s sink: %v", s.Kind(), err))
+					os.Exit(exitSink)
+				}
+			}
+		}
+	default:
+		reportFatal(fmt.Errorf("-format must be one of %s", strings.Join(exportFormats, ", ")))
+		os.Exit(exitConfig)
+	}
+	c.logf(levelInfo, "exported %d addresses", len(found))
+}
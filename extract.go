@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"runtime"
+	"sync"
+)
+
+// The extraction hot path works on the fetched bytes: matches are
+// sub-slices of the page collected in pooled buffers, and only the
+// addresses that pass the filter are copied out as strings.
+
+// mailBuffers holds the match buffers between two calls of extract
+var mailBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([][]byte, 0, 64)
+		return &b
+	},
+}
+
+// isWord reports whether b is in \w, the ASCII letters, digits and _
+func isWord(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' || b == '_'
+}
+
+// scanMails appends to dst what looks like an address in page, as matched
+// by `[\w]+@[\w.]+`, without allocating beyond growing dst
+func scanMails(dst [][]byte, page []byte) [][]byte {
+	end := 0 // the end of the previous match
+	for i := 0; i < len(page); {
+		at := bytes.IndexByte(page[i:], '@')
+		if at < 0 {
+			break
+		}
+		at += i
+		start := at
+		for start > end && isWord(page[start-1]) {
+			start--
+		}
+		stop := at + 1
+		for stop < len(page) && (isWord(page[stop]) || page[stop] == '.') {
+			stop++
+		}
+		if start == at || stop == at+1 {
+			i = at + 1
+			continue
+		}
+		dst = append(dst, page[start:stop])
+		end, i = stop, stop
+	}
+	return dst
+}
+
+// filterMails drops the matches of mails that aren't addresses, in place
+func filterMails(mails [][]byte) [][]byte {
+	kept := mails[:0]
+	for _, mail := range mails {
+		if freshMail(mail) {
+			kept = append(kept, mail)
+		}
+	}
+	return kept
+}
+
+// freshMail reports whether a match is worth keeping: not an image name,
+// with a dot somewhere after the local part, and not blacklisted
+func freshMail(mail []byte) bool {
+	switch {
+	case bytes.Contains(mail, []byte(".png")),
+		bytes.Contains(mail, []byte(".gif")),
+		bytes.Contains(mail, []byte(".jpg")),
+		bytes.Contains(mail, []byte("._")),
+		bytes.Contains(mail, []byte("@.")),
+		bytes.IndexByte(mail, '.') < 0:
+		return false
+	}
+	for _, black := range blacklist {
+		if string(mail) == black {
+			if c.verbosity >= levelDebug {
+				c.logf(levelDebug, "%s: blacklisted", mail)
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// chunks splits page into parts of about size bytes. Parts end on a byte
+// that can't be part of an address, so no address spans two parts and they
+// can be scanned on their own.
+func chunks(page []byte, size int) [][]byte {
+	var parts [][]byte
+	for len(page) > size {
+		cut := size
+		for cut < len(page) && (isWord(page[cut]) || page[cut] == '.' || page[cut] == '@') {
+			cut++
+		}
+		parts = append(parts, page[:cut])
+		page = page[cut:]
+	}
+	if len(page) == 0 && len(parts) > 0 {
+		// The last cut reached the end
+		return parts
+	}
+	return append(parts, page)
+}
+
+// scanLarge scans the chunks of a large page in parallel, appending the
+// matches to dst in page order
+func scanLarge(dst [][]byte, page []byte, size int) [][]byte {
+	if runtime.GOMAXPROCS(0) == 1 {
+		n := len(dst)
+		dst = scanMails(dst, page)
+		return append(dst[:n], filterMails(dst[n:])...)
+	}
+	parts := chunks(page, size)
+	found := make([][][]byte, len(parts))
+	next := make(chan int, len(parts))
+	for i := range parts {
+		next <- i
+	}
+	close(next)
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(parts) {
+		workers = len(parts)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				found[i] = filterMails(scanMails(nil, parts[i]))
+			}
+		}()
+	}
+	wg.Wait()
+	for _, f := range found {
+		dst = append(dst, f...)
+	}
+	return dst
+}
+
+// extract returns the valid email addresses in page
+func extract(url string, page []byte) []string {
+	buf := mailBuffers.Get().(*[][]byte)
+	defer func() {
+		// Don't hold on to the page through the buffer
+		for i := range *buf {
+			(*buf)[i] = nil
+		}
+		*buf = (*buf)[:0]
+		mailBuffers.Put(buf)
+	}()
+	if size := c.flags.chunkSize; size > 0 && len(page) > size {
+		*buf = scanLarge(*buf, page, size)
+	} else {
+		*buf = filterMails(scanMails(*buf, page))
+	}
+	if len(*buf) == 0 {
+		c.logf(levelInfo, "%s: no mail found", url)
+		return nil
+	}
+	mails := make([]string, len(*buf))
+	for i, m := range *buf {
+		mails[i] = string(m)
+	}
+	return mails
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// mailRegexp is the expression scanMails stands in for
+var mailRegexp = regexp.MustCompile(`[\w]+@[\w.]+`)
+
+func TestScanMails(t *testing.T) {
+	tests := []string{
+		"",
+		"no address here",
+		"alice@example.com",
+		"mail alice@example.com, bob@example.org.",
+		"@example.com and alice@ and @",
+		"a@b@c.d",
+		"x@@y.z",
+		"first.last@example.com",
+		"alice@example.com@example.org",
+		"under_score@sub.domain.example",
+		"ünï@cödé.com and ascii@tail.com",
+		"<a href=\"mailto:alice@example.com\">alice@example.com</a>",
+		"alice@example.com\nbob@example.org\r\ncarol@x",
+		"trailing@dots...",
+	}
+	for _, text := range tests {
+		var want [][]byte
+		for _, m := range mailRegexp.FindAll([]byte(text), -1) {
+			want = append(want, m)
+		}
+		if got := scanMails(nil, []byte(text)); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: matched %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestFilterMails(t *testing.T) {
+	tests := []struct {
+		mail string
+		want bool
+	}{
+		{"alice@example.com", true},
+		{"logo@2x.png", false},
+		{"icon@home.gif", false},
+		{"photo@big.jpg", false},
+		{"a@b._c", false},
+		{"a@.com", false},
+		{"root@localhost", false},
+	}
+	for _, tt := range tests {
+		got := len(filterMails([][]byte{[]byte(tt.mail)})) == 1
+		if got != tt.want {
+			t.Errorf("%s: kept %v, want %v", tt.mail, got, tt.want)
+		}
+	}
+}
+
+func TestChunks(t *testing.T) {
+	tests := []struct {
+		page string
+		size int
+		want []string
+	}{
+		{"", 4, []string{""}},
+		{"abc", 4, []string{"abc"}},
+		{"ab cd ef", 3, []string{"ab cd", " ef"}},
+		{"ab cd ef", 2, []string{"ab", " cd", " ef"}},
+		// A cut never falls inside what could be an address
+		{"x a@b.com y", 3, []string{"x a@b.com", " y"}},
+		{"alice@example.com", 2, []string{"alice@example.com"}},
+		{"a.b c", 1, []string{"a.b", " c"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, part := range chunks([]byte(tt.page), tt.size) {
+			got = append(got, string(part))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q by %d: %q, want %q", tt.page, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestScanLarge(t *testing.T) {
+	page := []byte(strings.Repeat("filler text alice@example.com, bob@example.org; logo@2x.png carol@x.io\n", 200))
+	want := filterMails(scanMails(nil, page))
+	for _, size := range []int{1, 7, 64, 1000, len(page)} {
+		got := scanLarge(nil, page, size)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("chunks of %d: %d matches, want %d", size, len(got), len(want))
+		}
+	}
+}
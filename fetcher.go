@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// Fetcher gets the pages of a source
+type Fetcher interface {
+	// Fetch GETs url with header set on the request. Responses of any
+	// status are returned; err is for when there is none.
+	Fetch(ctx context.Context, url string, header http.Header) (*Response, error)
+}
+
+// Response is a fetched page
+type Response struct {
+	StatusCode int
+	Status     string
+	Body       []byte
+}
+
+// httpFetcher is a Fetcher that goes through an HTTP client
+type httpFetcher struct {
+	client *http.Client
+}
+
+func (h *httpFetcher) Fetch(ctx context.Context, url string, header http.Header) (*Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	r := &Response{StatusCode: resp.StatusCode, Status: resp.Status}
+	if resp.StatusCode != http.StatusOK {
+		// Only the status of a failed request matters
+		return r, nil
+	}
+	r.Body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// memoryFetcher is a Fetcher serving the pages it was given, and 404 for
+// any other URL. It lets the sources and the pipeline run without a network.
+type memoryFetcher struct {
+	mu      sync.Mutex
+	pages   map[string]*Response
+	fetched []string
+}
+
+func newMemoryFetcher() *memoryFetcher {
+	return &memoryFetcher{pages: make(map[string]*Response)}
+}
+
+// set serves body with status at url
+func (m *memoryFetcher) set(url string, status int, body string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pages[url] = &Response{StatusCode: status, Status: statusLine(status), Body: []byte(body)}
+}
+
+// requests returns the URLs fetched so far, in order
+func (m *memoryFetcher) requests() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.fetched...)
+}
+
+func (m *memoryFetcher) Fetch(ctx context.Context, url string, header http.Header) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fetched = append(m.fetched, url)
+	r, ok := m.pages[url]
+	if !ok {
+		return &Response{StatusCode: http.StatusNotFound, Status: statusLine(http.StatusNotFound)}, nil
+	}
+	// A copy, so callers can't change what is served next
+	copied := *r
+	copied.Body = append([]byte(nil), r.Body...)
+	return &copied, nil
+}
+
+// statusLine returns the status text net/http gives a response with code
+func statusLine(code int) string {
+	return fmt.Sprintf("%d %s", code, http.StatusText(code))
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The tests share the global crawler, readied once as main would, with a
+// memoryFetcher in place of the network and every file in a temporary
+// directory.
+
+var (
+	testFetcher = newMemoryFetcher()
+	testOutput  string
+	testSetup   sync.Once
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if testOutput != "" {
+		os.RemoveAll(filepath.Dir(testOutput))
+	}
+	os.Exit(code)
+}
+
+func setupTest(t *testing.T) {
+	testSetup.Do(func() {
+		dir, err := ioutil.TempDir("", "mailbot")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testOutput = filepath.Join(dir, "out.txt")
+		c.flags.filename = testOutput
+		c.flags.deadletter = filepath.Join(dir, "deadletter.jsonl")
+		c.flags.alerts = filepath.Join(dir, "alerts.jsonl")
+		c.flags.spool = filepath.Join(dir, "spool")
+		c.flags.printToStdout = false
+		c.flags.quiet = true
+		// Findings stay buffered until closeSinks writes them
+		c.flags.batchSize = 1000
+		c.flags.batchInterval = time.Hour
+		c.fetcher = testFetcher
+		c.startLifecycle()
+		if err := c.setup(); err != nil {
+			t.Fatal(err)
+		}
+		c.open()
+	})
+}
+
+// archive lays out an archive page the way each source lists its pastes
+func archive(s *Source, ids ...string) string {
+	var b strings.Builder
+	for _, id := range ids {
+		switch s.Name {
+		case "pastebin":
+			b.WriteString(`<tr><td><img class="i_p0" alt="" /><a href="/` + id + `">` + id + "</a></td></tr>\n")
+		case "debian":
+			b.WriteString(`<li><a href='//paste.debian.net/` + id + `'>` + id + "</a></li>\n")
+		case "slexy":
+			b.WriteString(`<a href="/view/` + id + `">` + id + "</a>\n")
+		}
+	}
+	return b.String()
+}
+
+func TestSourceLinks(t *testing.T) {
+	setupTest(t)
+	tests := []struct {
+		source string
+		ids    []string
+		want   []string
+	}{
+		{"pastebin", []string{"abc", "def"}, []string{"https://pastebin.com/raw/abc", "https://pastebin.com/raw/def"}},
+		{"debian", []string{"123"}, []string{"http://paste.debian.net/123"}},
+		{"slexy", []string{"x1", "x2"}, []string{"http://slexy.org/raw/x1", "http://slexy.org/raw/x2"}},
+		{"pastebin", nil, nil},
+	}
+	for _, tt := range tests {
+		s := lookupSource(tt.source)
+		testFetcher.set(s.Archive, http.StatusOK, archive(s, tt.ids...))
+		page, err := c.FetchPage(s, s.Archive)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.source, err)
+		}
+		var got []string
+		for _, link := range s.Link.FindAllSubmatch(page, -1) {
+			got = append(got, s.Raw+string(link[1]))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: links %q, want %q", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestFetchStatus(t *testing.T) {
+	setupTest(t)
+	s := lookupSource("debian")
+	retries := s.network.Retries
+	s.network.Retries = 0
+	defer func() { s.network.Retries = retries }()
+	tests := []struct {
+		status  int
+		wantErr string
+	}{
+		{http.StatusOK, ""},
+		{http.StatusNotFound, "404 Not Found"},
+		{http.StatusServiceUnavailable, "503 Service Unavailable"},
+	}
+	for _, tt := range tests {
+		url := "http://paste.debian.net/status-" + http.StatusText(tt.status)
+		testFetcher.set(url, tt.status, "body")
+		_, err := c.FetchPage(s, url)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%d: %v", tt.status, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%d: error %v, want %q", tt.status, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCrawl(t *testing.T) {
+	setupTest(t)
+	s := lookupSource("pastebin")
+	tests := []struct {
+		name   string
+		pastes map[string]string
+		want   []string
+	}{
+		{
+			"addresses",
+			map[string]string{"p1": "mail alice@example.com or Bob@Example.org"},
+			[]string{"Bob@Example.org", "alice@example.com"},
+		},
+		{
+			"seen before",
+			map[string]string{"p2": "alice@example.com again, and carol@example.net"},
+			[]string{"carol@example.net"},
+		},
+		{
+			"no address",
+			map[string]string{"p3": "nothing to see"},
+			nil,
+		},
+		{
+			"missing paste",
+			map[string]string{"p4": ""},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		var ids []string
+		for id, body := range tt.pastes {
+			ids = append(ids, id)
+			if body != "" {
+				testFetcher.set(s.Raw+"/"+id, http.StatusOK, body)
+			}
+		}
+		testFetcher.set(s.Archive, http.StatusOK, archive(s, ids...))
+		before := readOutput(t)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		c.Crawl(s, &wg)
+		c.drain()
+		c.closeSinks()
+
+		got := readOutput(t)[len(before):]
+		sort.Strings(got)
+		if len(got) == 0 {
+			got = nil
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: wrote %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// A paste listed again isn't fetched again
+	fetched := 0
+	for _, url := range testFetcher.requests() {
+		if url == s.Raw+"/p1" {
+			fetched++
+		}
+	}
+	testFetcher.set(s.Archive, http.StatusOK, archive(s, "p1"))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	c.Crawl(s, &wg)
+	again := 0
+	for _, url := range testFetcher.requests() {
+		if url == s.Raw+"/p1" {
+			again++
+		}
+	}
+	if fetched != 1 || again != 1 {
+		t.Errorf("p1 fetched %d times, then %d, want once", fetched, again)
+	}
+}
+
+// readOutput returns the lines of the output file
+func readOutput(t *testing.T) []string {
+	b, err := ioutil.ReadFile(testOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
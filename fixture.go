@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fixturePath returns the file a response for url is recorded in
+func fixturePath(dir, url string) string {
+	sum := sha1.Sum([]byte(url))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".http")
+}
+
+// recorder saves every response it passes through to a fixture directory
+type recorder struct {
+	dir  string
+	next http.RoundTripper
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	// DumpResponse drains the body and replaces it with an in-memory copy
+	dump, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	url := req.URL.String()
+	path := fixturePath(r.dir, url)
+	// The URL goes first so fixtures can be told apart without an index
+	data := append([]byte(url+"\n"), dump...)
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		report(err)
+	} else if err := os.Rename(path+".tmp", path); err != nil {
+		report(err)
+	}
+	return resp, nil
+}
+
+// replayer serves recorded responses instead of going to the network
+type replayer struct {
+	dir string
+}
+
+func (r *replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	data, err := ioutil.ReadFile(fixturePath(r.dir, req.URL.String()))
+	if os.IsNotExist(err) {
+		return &http.Response{
+			Status:     "404 Not Found (no fixture)",
+			StatusCode: http.StatusNotFound,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     make(http.Header),
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+			Request:    req,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return readFixture(data, req)
+}
+
+// readFixture parses a recorded fixture back into a response
+func readFixture(data []byte, req *http.Request) (*http.Response, error) {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), req)
+}
+
+// fixtures wraps transport according to the -record and -replay flags
+func (c *Crawler) fixtures(transport http.RoundTripper) (http.RoundTripper, error) {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	switch {
+	case c.flags.replay != "":
+		return &replayer{dir: c.flags.replay}, nil
+	case c.flags.record != "":
+		if err := os.MkdirAll(c.flags.record, 0700); err != nil {
+			return nil, err
+		}
+		return &recorder{dir: c.flags.record, next: transport}, nil
+	}
+	return transport, nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The gRPC service of mailbot.proto, spoken directly over cleartext HTTP/2
+
+// gRPC status codes
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcNotFound          = 5
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnauthenticated   = 16
+)
+
+const grpcMaxMessageLength = 4 << 20
+
+// grpcError is an RPC failure with a gRPC status code
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string {
+	return e.msg
+}
+
+// grpcErrorf returns a *grpcError with the given code
+func grpcErrorf(code int, format string, args ...interface{}) error {
+	return &grpcError{code, fmt.Sprintf(format, args...)}
+}
+
+// unary is a unary RPC taking and returning encoded messages
+type unary func(req protoFields) ([]byte, error)
+
+// serveGRPC serves the Mailbot gRPC service on addr until it fails
+func (c *Crawler) serveGRPC(addr, token string) {
+	methods := map[string]unary{
+		"/mailbot.Mailbot/Pause":        c.grpcPause(true),
+		"/mailbot.Mailbot/Resume":       c.grpcPause(false),
+		"/mailbot.Mailbot/SetRateLimit": c.grpcSetRateLimit,
+		"/mailbot.Mailbot/Enqueue":      c.grpcEnqueue,
+		"/mailbot.Mailbot/TriggerCycle": c.grpcTriggerCycle,
+		"/mailbot.Mailbot/Stats":        c.grpcStats,
+	}
+	if c.leases != nil {
+		methods["/mailbot.Coordinator/Lease"] = c.grpcLease
+		methods["/mailbot.Coordinator/Heartbeat"] = c.grpcHeartbeat
+		methods["/mailbot.Coordinator/Complete"] = c.grpcComplete
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			grpcFinish(w, grpcErrorf(grpcUnauthenticated, "invalid token"))
+			return
+		}
+		if r.URL.Path == "/mailbot.Mailbot/Subscribe" {
+			grpcFinish(w, c.grpcSubscribe(w, r))
+			return
+		}
+		call, ok := methods[r.URL.Path]
+		if !ok {
+			grpcFinish(w, grpcErrorf(grpcUnimplemented, "unknown method %s", r.URL.Path))
+			return
+		}
+		req, err := readGRPCMessage(r.Body)
+		if err != nil {
+			grpcFinish(w, grpcErrorf(grpcInvalidArgument, "%v", err))
+			return
+		}
+		fields, err := decodeProto(req)
+		if err != nil {
+			grpcFinish(w, grpcErrorf(grpcInvalidArgument, "%v", err))
+			return
+		}
+		reply, err := call(fields)
+		if err == nil {
+			err = writeGRPCMessage(w, reply)
+		}
+		grpcFinish(w, err)
+	})
+
+	srv := &http.Server{Addr: addr, Handler: handler}
+	srv.Protocols = new(http.Protocols)
+	srv.Protocols.SetUnencryptedHTTP2(true)
+	c.listen("grpc", srv)
+}
+
+// readGRPCMessage reads one length-prefixed message
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, errors.New("compressed messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(header[1:])
+	if n > grpcMaxMessageLength {
+		return nil, errors.New("message too large")
+	}
+	msg := make([]byte, n)
+	_, err := io.ReadFull(r, msg)
+	return msg, err
+}
+
+// writeGRPCMessage writes one length-prefixed message and flushes it
+func writeGRPCMessage(w http.ResponseWriter, msg []byte) error {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	if _, err := w.Write(append(frame, msg...)); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// grpcFinish sends the status trailers for err
+func grpcFinish(w http.ResponseWriter, err error) {
+	code, msg := grpcOK, ""
+	if err != nil {
+		code, msg = grpcInternal, err.Error()
+		if ge, ok := err.(*grpcError); ok {
+			code = ge.code
+		}
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set("Grpc-Message", url.PathEscape(msg))
+	}
+}
+
+// grpcSubscribe streams findings until the client goes away
+func (c *Crawler) grpcSubscribe(w http.ResponseWriter, r *http.Request) error {
+	findings, cancel := c.broker.subscribe()
+	defer cancel()
+	// Send the headers right away so the client sees the stream open
+	w.WriteHeader(http.StatusOK)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case f := <-findings:
+			if err := writeGRPCMessage(w, encodeFinding(f)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func encodeFinding(f Finding) []byte {
+	var b []byte
+	b = appendString(b, 1, f.Email)
+	b = appendString(b, 2, f.Source)
+	b = appendString(b, 3, f.URL)
+	b = appendInt64(b, 4, f.Time.UnixNano())
+	b = appendString(b, 5, f.Campaign)
+	return b
+}
+
+func encodeAck(msg string) []byte {
+	return appendString(nil, 1, msg)
+}
+
+func (c *Crawler) grpcPause(paused bool) unary {
+	return func(req protoFields) ([]byte, error) {
+		name := req.string(1)
+		if _, err := c.setPaused(name, paused); err != nil {
+			return nil, grpcErrorf(grpcNotFound, "%v", err)
+		}
+		if name == "" {
+			name = "crawler"
+		}
+		if paused {
+			return encodeAck(name + " paused"), nil
+		}
+		return encodeAck(name + " resumed"), nil
+	}
+}
+
+func (c *Crawler) grpcSetRateLimit(req protoFields) ([]byte, error) {
+	delay := time.Duration(req.int64(2)) * time.Millisecond
+	if delay < 0 {
+		return nil, grpcErrorf(grpcInvalidArgument, "delay must not be negative")
+	}
+	targets, err := selectSources(req.string(1))
+	if err != nil {
+		return nil, grpcErrorf(grpcNotFound, "%v", err)
+	}
+	for _, s := range targets {
+		s.setRateLimit(delay)
+	}
+	return encodeAck("rate limit set to " + delay.String()), nil
+}
+
+func (c *Crawler) grpcEnqueue(req protoFields) ([]byte, error) {
+	s := lookupSource(req.string(1))
+	if s == nil {
+		return nil, grpcErrorf(grpcNotFound, "unknown source %q", req.string(1))
+	}
+	u := req.string(2)
+	if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		return nil, grpcErrorf(grpcInvalidArgument, "url must be an http or https URL")
+	}
+	select {
+	case c.queue <- queued{s, u}:
+		return encodeAck("queued"), nil
+	default:
+		return nil, grpcErrorf(grpcResourceExhausted, "%v", errQueueFull)
+	}
+}
+
+func (c *Crawler) grpcTriggerCycle(req protoFields) ([]byte, error) {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return encodeAck("cycle triggered"), nil
+}
+
+func (c *Crawler) grpcStats(req protoFields) ([]byte, error) {
+	var reply []byte
+	for _, st := range c.sourceStats() {
+		var m []byte
+		m = appendString(m, 1, st.Source)
+		m = appendBool(m, 2, st.Enabled)
+		m = appendInt64(m, 3, st.Listed)
+		m = appendInt64(m, 4, st.Fetched)
+		m = appendInt64(m, 5, st.Skipped)
+		m = appendInt64(m, 6, st.Emails)
+		m = appendInt64(m, 7, st.Duplicates)
+		m = appendInt64(m, 8, st.Requests)
+		m = appendInt64(m, 9, st.Errors)
+		m = appendDouble(m, 10, st.ErrorRate)
+		if st.LastSuccess != nil {
+			m = appendInt64(m, 11, st.LastSuccess.UnixNano())
+		}
+		reply = appendMessage(reply, 1, m)
+	}
+	return reply, nil
+}
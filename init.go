@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultConfigFile is where mailbot init writes the config
+const DefaultConfigFile = "mailbot.json"
+
+// wizard asks questions on out and reads the answers from in
+type wizard struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask prints question and returns the answer, or def for an empty one.
+// valid, if set, rejects answers until one passes it.
+func (w *wizard) ask(question, def string, valid func(string) error) string {
+	for {
+		if def != "" {
+			fmt.Fprintf(w.out, "%s [%s]: ", question, def)
+		} else {
+			fmt.Fprintf(w.out, "%s: ", question)
+		}
+		line, err := w.in.ReadString('\n')
+		answer := strings.TrimSpace(line)
+		if answer == "" {
+			answer = def
+		}
+		if err != nil && line == "" {
+			// Out of input: take the defaults from here on
+			fmt.Fprintln(w.out)
+			return def
+		}
+		if valid == nil {
+			return answer
+		}
+		if err := valid(answer); err != nil {
+			fmt.Fprintf(w.out, "  %v\n", err)
+			continue
+		}
+		return answer
+	}
+}
+
+// confirm asks a yes/no question
+func (w *wizard) confirm(question string, def bool) bool {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	answer := w.ask(question+" ("+hint+")", "", func(s string) error {
+		switch strings.ToLower(s) {
+		case "", "y", "yes", "n", "no":
+			return nil
+		}
+		return fmt.Errorf("answer y or n")
+	})
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	}
+	return def
+}
+
+func validDuration(s string) error {
+	if s == "" {
+		return nil
+	}
+	_, err := time.ParseDuration(s)
+	return err
+}
+
+func validCount(s string) error {
+	if n, err := strconv.Atoi(s); err != nil || n < 0 {
+		return fmt.Errorf("want a number, 0 or more")
+	}
+	return nil
+}
+
+func validFile(s string) error {
+	if s == "" {
+		return nil
+	}
+	_, err := os.Stat(s)
+	return err
+}
+
+// configEntry is a line of the generated config, with the comment above it
+type configEntry struct {
+	comment string
+	key     string
+	value   interface{}
+}
+
+// writeSection writes entries as the JSON object key, each with its
+// comment. last leaves out the comma after it.
+func writeSection(w io.Writer, key, comment string, entries []configEntry, last bool) {
+	if len(entries) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "  // %s\n  %q: {\n", comment, key)
+	for i, e := range entries {
+		if e.comment != "" {
+			fmt.Fprintf(w, "    // %s\n", e.comment)
+		}
+		v, _ := json.Marshal(e.value)
+		comma := ","
+		if i == len(entries)-1 {
+			comma = ""
+		}
+		fmt.Fprintf(w, "    %q: %s%s\n", e.key, v, comma)
+	}
+	if last {
+		fmt.Fprint(w, "  }\n")
+	} else {
+		fmt.Fprint(w, "  },\n")
+	}
+}
+
+// Init asks which sources, sinks, limits and filters to use and writes
+// them to a commented config file at path
+func (c *Crawler) Init(path string) {
+	if path == "" {
+		path = DefaultConfigFile
+	}
+	if _, err := os.Stat(path); err == nil {
+		reportFatal(fmt.Errorf("%s already exists", path))
+		os.Exit(exitConfig)
+	}
+	w := &wizard{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+	fmt.Fprintf(w.out, "Writing a mailbot config to %s. Press enter to keep the default.\n\n", path)
+
+	var options, network []configEntry
+	for _, s := range sources {
+		enabled := w.confirm("Crawl "+s.Site+"?", true)
+		options = append(options, configEntry{"", s.Name, enabled})
+	}
+	options[0].comment = "Sources to crawl"
+
+	output := w.ask("File to write the addresses to", "mailbot.txt", nil)
+	options = append(options, configEntry{"Where findings go", "o", output})
+	if hook := w.ask("URL to post findings to as JSON (empty for none)", "", nil); hook != "" {
+		options = append(options, configEntry{"", "sink-webhook", hook})
+	}
+	if es := w.ask("Elasticsearch URL to index findings to (empty for none)", "", nil); es != "" {
+		options = append(options, configEntry{"", "elasticsearch", es})
+	}
+
+	interval := w.ask("Delay between two crawl cycles", "1m", validDuration)
+	options = append(options, configEntry{"How often the archives are read", "interval", interval})
+	if rate := w.ask("Minimum delay between two requests to the same site (empty for none)", "", validDuration); rate != "" {
+		network = append(network, configEntry{"Minimum delay between two requests to the same site", "rate_limit", rate})
+	}
+	if budget := w.ask("Maximum requests per cycle across all sources (0 for no limit)", "0", validCount); budget != "0" {
+		n, _ := strconv.Atoi(budget)
+		options = append(options, configEntry{"Requests a cycle may make over all sources", "max-requests-per-cycle", n})
+	}
+
+	if list := w.ask("File of addresses and domains to alert on (empty for none)", "", validFile); list != "" {
+		options = append(options, configEntry{"Alert on findings in these addresses or domains", "watchlist", list})
+	}
+	if words := w.ask("File of keywords to alert on (empty for none)", "", validFile); words != "" {
+		options = append(options, configEntry{"Alert on pastes containing these keywords", "keywords", words})
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		reportFatal(err)
+		os.Exit(exitConfig)
+	}
+	b := bufio.NewWriter(f)
+	fmt.Fprintf(b, "// mailbot config written by \"mailbot init\" on %s.\n", time.Now().Format("2006-01-02"))
+	fmt.Fprintf(b, "// Use it with: mailbot -config %s\n{\n", path)
+	writeSection(b, "options", "Flags, by name without the dash; the command line overrides them", options, len(network) == 0)
+	writeSection(b, "network", "Network settings for every source, see \"sources\" in the README for one", network, true)
+	fmt.Fprint(b, "}\n")
+	if err := b.Flush(); err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		reportFatal(err)
+		os.Exit(exitConfig)
+	}
+	// Read it back, so what was written is known to load
+	if err := c.loadConfig(path); err != nil {
+		reportFatal(err)
+		os.Exit(exitConfig)
+	}
+	fmt.Fprintf(w.out, "\nWrote %s. Start crawling with: mailbot -config %s\n", path, path)
+}
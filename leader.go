@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Instances sharing a Redis server elect a leader, the only one doing the
+// fleet's scheduled duties: sending the digests and pruning the shared
+// digest list. Without Redis an instance is always its own leader.
+
+const (
+	renewScript   = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`
+	releaseScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+)
+
+// isLeader reports whether this instance does the fleet's singleton duties
+func (c *Crawler) isLeader() bool {
+	return c.redis == nil || atomic.LoadInt32(&c.leader) != 0
+}
+
+// elect campaigns for leadership every -leader-ttl/3, renewing it while it
+// is held
+func (c *Crawler) elect() {
+	b := make([]byte, 4)
+	rand.Read(b)
+	host, _ := os.Hostname()
+	c.leaderID = fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
+	key := c.flags.redisPrefix + "leader"
+	ttl := strconv.FormatInt(c.flags.leaderTTL.Milliseconds(), 10)
+	for {
+		var won bool
+		if atomic.LoadInt32(&c.leader) != 0 {
+			n, err := c.redis.int("EVAL", renewScript, "1", key, c.leaderID, ttl)
+			if err != nil {
+				report(fmt.Errorf("leader: %v", err))
+			}
+			won = err == nil && n == 1
+		} else {
+			reply, err := c.redis.do("SET", key, c.leaderID, "NX", "PX", ttl)
+			if err != nil {
+				report(fmt.Errorf("leader: %v", err))
+			}
+			won = err == nil && reply == "OK"
+		}
+		c.setLeader(won)
+		if !c.sleep(c.flags.leaderTTL / 3) {
+			return
+		}
+	}
+}
+
+// setLeader records and announces a change of leadership
+func (c *Crawler) setLeader(leader bool) {
+	var v int32
+	if leader {
+		v = 1
+	}
+	if atomic.SwapInt32(&c.leader, v) == v {
+		return
+	}
+	if leader {
+		c.logf(levelInfo, "leader: elected as %s", c.leaderID)
+	} else {
+		c.logf(levelInfo, "leader: lost leadership")
+	}
+	c.emit(eventLeader, map[string]interface{}{"leader": leader, "id": c.leaderID})
+}
+
+// resign gives up leadership so another instance takes over right away
+func (c *Crawler) resign() {
+	if c.redis == nil || atomic.LoadInt32(&c.leader) == 0 {
+		return
+	}
+	c.redis.do("EVAL", releaseScript, "1", c.flags.redisPrefix+"leader", c.leaderID)
+}
+
+// addDigest queues f for the next digest. With Redis the findings of every
+// instance go to a shared list, for the leader to send.
+func (c *Crawler) addDigest(f Finding) {
+	if c.redis == nil {
+		c.digest.add(f)
+		return
+	}
+	b, _ := json.Marshal(f)
+	if _, err := c.redis.do("RPUSH", c.flags.redisPrefix+"digest", string(b)); err != nil {
+		report(fmt.Errorf("digest: %v", err))
+	}
+}
+
+// pruneDigest drops the findings dated before cutoff from the digest. The
+// Redis list is in the order findings were added, so only its head is read.
+func (c *Crawler) pruneDigest(cutoff time.Time) int {
+	if c.redis == nil {
+		return c.digest.prune(cutoff)
+	}
+	key := c.flags.redisPrefix + "digest"
+	var pruned int
+	for {
+		reply, err := c.redis.do("LRANGE", key, "0", "99")
+		if err != nil {
+			report(fmt.Errorf("retention: digest: %v", err))
+			return pruned
+		}
+		items, _ := reply.([]interface{})
+		for _, item := range items {
+			raw, _ := item.(string)
+			var f Finding
+			if json.Unmarshal([]byte(raw), &f) == nil && !f.Time.Before(cutoff) {
+				return pruned
+			}
+			// By value, as the leader may have taken the list meanwhile
+			n, err := c.redis.int("LREM", key, "1", raw)
+			if err != nil {
+				report(fmt.Errorf("retention: digest: %v", err))
+				return pruned
+			}
+			pruned += int(n)
+		}
+		if len(items) < 100 {
+			return pruned
+		}
+	}
+}
+
+// takeDigest empties the digest
+func (c *Crawler) takeDigest() ([]Finding, int) {
+	if c.redis == nil {
+		return c.digest.take()
+	}
+	key := c.flags.redisPrefix + "digest"
+	sending := key + ":sending"
+	// Findings added from now on go to the next digest
+	if _, err := c.redis.do("RENAME", key, sending); err != nil {
+		if _, ok := err.(redisError); !ok {
+			report(fmt.Errorf("digest: %v", err))
+		}
+		return nil, 0
+	}
+	defer c.redis.do("DEL", sending)
+	total, err := c.redis.int("LLEN", sending)
+	if err != nil {
+		report(fmt.Errorf("digest: %v", err))
+		return nil, 0
+	}
+	reply, err := c.redis.do("LRANGE", sending, "0", strconv.Itoa(digestLimit-1))
+	if err != nil {
+		report(fmt.Errorf("digest: %v", err))
+		return nil, 0
+	}
+	items, _ := reply.([]interface{})
+	var findings []Finding
+	for _, item := range items {
+		var f Finding
+		if raw, ok := item.(string); ok && json.Unmarshal([]byte(raw), &f) == nil {
+			findings = append(findings, f)
+		}
+	}
+	return findings, int(total) - len(findings)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Every goroutine mailbot starts for its own work runs through spawn,
+// which records it until it returns, and stops when c.ctx is cancelled.
+// shutdown cancels it and reports those still running after
+// -shutdown-timeout.
+
+// tracked is a running goroutine
+type tracked struct {
+	name    string
+	started time.Time
+}
+
+// tracker is the set of running goroutines
+type tracker struct {
+	mu      sync.Mutex
+	next    int64
+	running map[int64]tracked
+}
+
+func (t *tracker) add(name string) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.running == nil {
+		t.running = make(map[int64]tracked)
+	}
+	t.next++
+	t.running[t.next] = tracked{name, time.Now()}
+	return t.next
+}
+
+func (t *tracker) remove(id int64) {
+	t.mu.Lock()
+	delete(t.running, id)
+	t.mu.Unlock()
+}
+
+// wait waits up to timeout for every goroutine to return and returns
+// those that didn't, oldest first
+func (t *tracker) wait(timeout time.Duration) []tracked {
+	deadline := time.Now().Add(timeout)
+	for {
+		t.mu.Lock()
+		n := len(t.running)
+		t.mu.Unlock()
+		if n == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var left []tracked
+	for _, g := range t.running {
+		left = append(left, g)
+	}
+	sort.Slice(left, func(i, j int) bool { return left[i].started.Before(left[j].started) })
+	return left
+}
+
+// startLifecycle creates the context every goroutine stops on
+func (c *Crawler) startLifecycle() {
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.started = time.Now()
+}
+
+// spawn runs fn in a new tracked goroutine once fewer than
+// -max-goroutines are running
+func (c *Crawler) spawn(name string, fn func()) {
+	if max := c.flags.maxGoroutines; max > 0 {
+		for logged := false; runtime.NumGoroutine() >= max; time.Sleep(10 * time.Millisecond) {
+			if !logged {
+				c.logf(levelDebug, "%d goroutines running, waiting", max)
+				logged = true
+			}
+		}
+	}
+	id := c.goroutines.add(name)
+	go func() {
+		defer c.goroutines.remove(id)
+		fn()
+	}()
+}
+
+// sleep waits for d and reports false if the crawler stopped meanwhile
+func (c *Crawler) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+// tick waits for the next tick of t and reports false if the crawler
+// stopped meanwhile
+func (c *Crawler) tick(t *time.Ticker) bool {
+	select {
+	case <-t.C:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+// listen serves srv in a tracked goroutine until the crawler stops
+func (c *Crawler) listen(name string, srv *http.Server) {
+	c.spawn(name, func() {
+		go func() {
+			<-c.ctx.Done()
+			srv.Close()
+		}()
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			report(err)
+		}
+	})
+}
+
+// limitResults returns as many of the new addresses fresh as -max-results
+// still allows, shutting down once it is reached. Those left out are
+// forgotten, so that a later run still finds them. It is called by the
+// sink only.
+func (c *Crawler) limitResults(s *Source, fresh []string) []string {
+	max := c.flags.maxResults
+	if max <= 0 || len(fresh) == 0 {
+		return fresh
+	}
+	keep := max - c.results
+	if keep < 0 {
+		keep = 0
+	}
+	if keep < len(fresh) {
+		for _, mail := range fresh[keep:] {
+			c.store.Remove(kindEmail, campaignKey(s.campaign, c.storeKey(mail)))
+		}
+		fresh = fresh[:keep]
+	}
+	reached := c.results < max && c.results+len(fresh) >= max
+	c.results += len(fresh)
+	if reached {
+		c.logf(levelInfo, "%d results written, stopping", max)
+		// Not from the sink, which shutdown waits for
+		go c.shutdown("max results reached", exitOK)
+	}
+	return fresh
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errLocked is returned by lockFile when another process holds the lock
+var errLocked = errors.New("locked by another process")
+
+// lockedError says which instance holds the lock on an output
+type lockedError struct {
+	path string
+	pid  string
+}
+
+func (e *lockedError) Error() string {
+	return fmt.Sprintf("%s is in use by another mailbot (pid %s)", e.path, e.pid)
+}
+
+// lockOutput takes the lock on the output file, held until mailbot exits,
+// so that no two instances append to it at once. It is taken on a file
+// beside it, path.lock, which holds the pid of the instance holding it.
+func (c *Crawler) lockOutput() error {
+	if c.lock != nil {
+		return nil
+	}
+	path := c.flags.filename
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if err := lockFile(f); err != nil {
+		defer f.Close()
+		if err != errLocked {
+			return fmt.Errorf("lock %s: %v", f.Name(), err)
+		}
+		if pid, err := lockHolder(f); err == nil && pid > 0 {
+			return &lockedError{path, strconv.Itoa(pid)}
+		}
+		pid, _ := ioutil.ReadAll(f)
+		return &lockedError{path, strings.TrimSpace(string(pid))}
+	}
+	f.Truncate(0)
+	f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	c.lock = f
+	return nil
+}
+
+// mustLock takes the lock on the output or exits
+func (c *Crawler) mustLock() {
+	if err := c.lockOutput(); err != nil {
+		reportFatal(err)
+		os.Exit(exitLocked)
+	}
+}
+
+// Companion follows the output of the instance holding its lock instead
+// of crawling: the addresses already there can be searched on -web, and
+// those written from then on are printed and shown live by -web and
+// -prometheus. Nothing is written: the store is kept in memory whatever
+// -redis says. It exits when that instance does.
+func (c *Crawler) Companion() {
+	// Followed from where it ends now, as the lines written while it is
+	// preloaded are new
+	var start int64
+	if info, err := os.Stat(c.flags.filename); err == nil {
+		start = info.Size()
+	}
+	c.store = newMemoryStore()
+	if err := c.preload(c.flags.filename); err != nil {
+		reportFatal(err)
+		os.Exit(exitSink)
+	}
+	if c.flags.prometheus != "" {
+		c.servePrometheus(c.flags.prometheus)
+	}
+	if c.flags.web != "" {
+		token, _ := c.apiToken()
+		c.serveWeb(c.flags.web, token)
+	}
+	c.handleSignals()
+	if c.flags.maxRuntime > 0 {
+		time.AfterFunc(c.flags.maxRuntime, func() {
+			c.shutdown("max runtime reached", exitOK)
+		})
+	}
+	c.spawn("follow output", func() { c.follow(start) })
+	// shutdown exits once the other goroutines are done
+	select {}
+}
+
+// follow reads the lines appended to the output past offset start every
+// second, reopening it when it was replaced, as by -retention or mailbot
+// suppress
+func (c *Crawler) follow(start int64) {
+	var (
+		f       *os.File
+		partial []byte
+		t       = time.NewTicker(time.Second)
+	)
+	defer t.Stop()
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	for c.tick(t) {
+		if held, err := c.outputLocked(); err == nil && !held {
+			go c.shutdown("instance holding the lock exited", exitOK)
+			return
+		}
+		info, err := os.Stat(c.flags.filename)
+		if err != nil {
+			continue
+		}
+		if f != nil {
+			if open, err := f.Stat(); err != nil || !os.SameFile(open, info) {
+				f.Close()
+				f = nil
+			}
+		}
+		if f == nil {
+			if f, err = os.Open(c.flags.filename); err != nil {
+				report(err)
+				continue
+			}
+			// What is already there was printed by the other instance
+			if start >= 0 {
+				f.Seek(start, io.SeekStart)
+				start = -1
+			} else {
+				f.Seek(0, io.SeekEnd)
+			}
+			partial = nil
+		}
+		b, err := ioutil.ReadAll(f)
+		if err != nil {
+			report(err)
+			continue
+		}
+		b = append(partial, b...)
+		end := strings.LastIndexByte(string(b), '\n') + 1
+		partial = append([]byte(nil), b[end:]...)
+		for _, line := range strings.Split(string(b[:end]), "\n") {
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+			found := parsePlain(line)
+			if found.Source != "" {
+				c.count(metricEmails, found.Source, 1)
+			}
+			campaign := c.flags.campaign
+			if found.Source != "" {
+				campaign = found.Campaign
+			}
+			c.store.Add(kindEmail, campaignKey(campaign, found.Email))
+			c.recent.add(found)
+			c.broker.publish(found)
+			if c.flags.printToStdout {
+				fmt.Println(line)
+			}
+		}
+	}
+}
+
+// outputLocked reports whether another instance still holds the lock on
+// the output, without taking it, so that a new one can start meanwhile
+func (c *Crawler) outputLocked() (bool, error) {
+	f, err := os.Open(c.flags.filename + ".lock")
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	pid, err := lockHolder(f)
+	return pid > 0, err
+}
@@ -0,0 +1,36 @@
+//go:build unix
+
+package main
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive advisory lock on f, opened for writing,
+// without waiting for it. It is a POSIX record lock, so that lockHolder can
+// tell who holds it without taking it. The kernel releases it when the
+// process exits, however it exits, and as soon as the process closes any
+// descriptor of the file: nothing but lockFile may open it.
+func lockFile(f *os.File) error {
+	lk := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: io.SeekStart}
+	err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &lk)
+	if err == syscall.EAGAIN || err == syscall.EACCES {
+		return errLocked
+	}
+	return err
+}
+
+// lockHolder returns the pid of the process holding the lock on f, or 0 if
+// none does
+func lockHolder(f *os.File) (int, error) {
+	lk := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: io.SeekStart}
+	if err := syscall.FcntlFlock(f.Fd(), syscall.F_GETLK, &lk); err != nil {
+		return 0, err
+	}
+	if lk.Type == syscall.F_UNLCK {
+		return 0, nil
+	}
+	return int(lk.Pid), nil
+}
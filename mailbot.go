@@ -1,16 +1,18 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,17 +21,167 @@ var (
 	DefaultFileName = "crawler" + strconv.FormatInt(time.Now().UnixNano(), 36) + ".log"
 )
 
+// validCampaign matches the campaign labels findings can be tagged with
+var validCampaign = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Exit codes
+const (
+	exitOK             = 0
+	exitConfig         = 2
+	exitSourcesFailing = 3
+	exitSink           = 4
+	exitLocked         = 5
+	exitUpdate         = 6
+)
+
 // Crawler holds the flags and locks
 type Crawler struct {
 	flags struct {
-		filename      string
-		printToStdout bool
-		verbose       bool
-		pastebin      bool
-		debian        bool
-		slexy         bool
-	}
-	file *os.File
+		filename       string
+		printToStdout  bool
+		verbose        [4]bool
+		quiet          bool
+		pretty         bool
+		network        Network
+		deadletter     string
+		record         string
+		replay         string
+		config         string
+		concurrency    int
+		maxFetches     int
+		maxRequests    int
+		interval       time.Duration
+		minInterval    time.Duration
+		maxInterval    time.Duration
+		prometheus     string
+		statsd         string
+		statsdPrefix   string
+		statsdTags     string
+		dogstatsd      bool
+		statsdPush     time.Duration
+		summary        time.Duration
+		summaryFile    string
+		tui            bool
+		web            string
+		api            string
+		apiToken       string
+		grpc           string
+		events         string
+		eventsFD       int
+		maxErrors      int
+		sentry         string
+		audit          string
+		redis          string
+		redisPrefix    string
+		coordinator    bool
+		coordinatorURL string
+		leaseTTL       time.Duration
+		leaderTTL      time.Duration
+		shard          string
+		fetchQueue     int
+		extractQueue   int
+		extractWorkers int
+		sinkQueue      int
+		chunkSize      int
+		maxMemory      string
+		maxGoroutines  int
+		shutdownWait   time.Duration
+		shardBy        string
+		sentryFailures int
+		maxRuntime     time.Duration
+		smtp           string
+		smtpUser       string
+		smtpFrom       string
+		smtpTo         string
+		smtpTLS        string
+		smtpInsecure   bool
+		digest         time.Duration
+		watchlist      string
+		keywords       string
+		alerts         string
+		webhook        string
+		sinkWebhook    string
+		elasticsearch  string
+		elasticIndex   string
+		batchSize      int
+		batchInterval  time.Duration
+		sinkBacklog    int
+		spool          string
+		selftestServer bool
+		selftestRate   float64
+		selftestEmails int
+		selftestSize   int
+		selftestDelay  time.Duration
+		selftestLimit  int
+		checkMX        bool
+		disposable     string
+		json           bool
+		statsTop       int
+		statsBucket    time.Duration
+		exportFormat   string
+		driftCycles    int
+		suppress       string
+		compliance     bool
+		robots         bool
+		redact         string
+		retention      time.Duration
+		campaign       string
+		provenance     bool
+		since          string
+		maxResults     int
+		until          string
+		companion      bool
+		probe          bool
+		updateURL      string
+		updateKey      string
+		force          bool
+		webOrigins     string
+		dispatch       bool
+	}
+	watchlist  *watchlist
+	keywords   *regexp.Regexp
+	sentry     *sentry
+	audit      audit
+	redis      *redisClient
+	jobs       jobQueue
+	leases     *leaseQueue
+	shard      *shard
+	leader     int32
+	pipeline   pipeline
+	sched      scheduler
+	selftest   *selftest
+	ctx        context.Context
+	cancel     context.CancelFunc
+	goroutines tracker
+	stopping   sync.Once
+	started    time.Time
+	leaderID   string
+	alerts     alerts
+	notifiers  []*queuedNotifier
+	mailer     *smtpNotifier
+	digest     digest
+	events     events
+	broker     broker
+	paused     int32
+	queue      chan queued
+	wake       chan struct{}
+	tui        *TUI
+	recent     recentFindings
+	store      Store
+	suppressed suppressions
+	robots     robotsCache
+	fetcher    Fetcher
+	metrics    Metrics
+	config     Config
+	command    string
+	verbosity  int
+	sinks      []*batchedSink
+	results    int
+	deadletter *os.File
+	deadLost   int64
+	lock       *os.File
+	mu         sync.Mutex
+	requests   int64
 }
 
 var blacklist = []string{
@@ -40,7 +192,6 @@ var blacklist = []string{
 var c = new(Crawler)
 
 func init() {
-	var err error
 	flag.StringVar(
 		&c.flags.filename,
 		"o",
@@ -54,205 +205,1279 @@ func init() {
 		"Print to stdout",
 	)
 	flag.BoolVar(
-		&c.flags.verbose,
+		&c.flags.verbose[1],
+		"v",
+		false,
+		"Verbose: also report pastes without mail, retries and skipped work",
+	)
+	flag.BoolVar(
+		&c.flags.verbose[2],
+		"vv",
+		false,
+		"More verbose: also report every fetched URL",
+	)
+	flag.BoolVar(
+		&c.flags.verbose[3],
+		"vvv",
+		false,
+		"Most verbose: also report rate limit waits and response details",
+	)
+	flag.BoolVar(
+		&c.flags.verbose[1],
 		"verbose",
 		false,
-		"Verbose mode",
+		"Same as -v",
 	)
 	flag.BoolVar(
-		&c.flags.pastebin,
-		"pastebin",
-		true,
-		"Crawl pastebin.com",
+		&c.flags.pretty,
+		"pretty",
+		false,
+		"Colored console output with progress, when stdout is a terminal",
 	)
 	flag.BoolVar(
-		&c.flags.debian,
-		"debian",
-		true,
-		"Crawl paste.debian.net",
+		&c.flags.quiet,
+		"quiet",
+		false,
+		"Print nothing but the findings on stdout and, on stderr, the error mailbot exits on",
+	)
+	for _, s := range sources {
+		flag.BoolVar(
+			&s.enabled,
+			s.Name,
+			true,
+			"Crawl "+s.Site,
+		)
+		flag.StringVar(
+			&s.bindAddress,
+			s.Name+"-bind-address",
+			"",
+			"Local IP or interface used for "+s.Site+" (overrides -bind-address)",
+		)
+		flag.StringVar(
+			&s.sinceFlag,
+			s.Name+"-since",
+			"",
+			"Only take the pastes of "+s.Site+" posted since then (overrides -since)",
+		)
+		flag.StringVar(
+			&s.untilFlag,
+			s.Name+"-until",
+			"",
+			"Only take the pastes of "+s.Site+" posted before then (overrides -until)",
+		)
+	}
+	flag.StringVar(
+		&c.flags.network.BindAddress,
+		"bind-address",
+		"",
+		"Local IP or interface to send requests from",
+	)
+	flag.DurationVar(
+		&c.flags.network.Timeout,
+		"timeout",
+		30*time.Second,
+		"Timeout of a single request",
+	)
+	flag.StringVar(
+		&c.flags.network.Proxy,
+		"proxy",
+		"",
+		"Proxy URL to send requests through (http, https or socks5)",
+	)
+	flag.DurationVar(
+		&c.flags.network.RateLimit,
+		"rate-limit",
+		0,
+		"Minimum delay between two requests to the same source",
+	)
+	flag.Float64Var(
+		&c.flags.network.Jitter,
+		"jitter",
+		0.2,
+		"Randomize delays by up to this fraction, e.g. 0.2 for ±20%",
+	)
+	flag.DurationVar(
+		&c.flags.interval,
+		"interval",
+		time.Minute,
+		"Delay between two crawl cycles",
+	)
+	flag.DurationVar(
+		&c.flags.minInterval,
+		"min-interval",
+		0,
+		"Shortest delay a busy source is polled at (default -interval)",
+	)
+	flag.DurationVar(
+		&c.flags.maxInterval,
+		"max-interval",
+		0,
+		"Longest delay a quiet source is polled at (default -interval)",
+	)
+	flag.IntVar(
+		&c.flags.network.Retries,
+		"retries",
+		3,
+		"Number of times a failed fetch is retried",
+	)
+	flag.DurationVar(
+		&c.flags.network.RetryBackoff,
+		"retry-backoff",
+		2*time.Second,
+		"Delay before the first retry, doubled on every further attempt",
+	)
+	flag.StringVar(
+		&c.flags.deadletter,
+		"deadletter",
+		"deadletter.jsonl",
+		"File to record URLs that failed all retries",
+	)
+	flag.StringVar(
+		&c.flags.record,
+		"record",
+		"",
+		"Directory to record every fetched response to",
+	)
+	flag.StringVar(
+		&c.flags.replay,
+		"replay",
+		"",
+		"Directory of recorded responses to serve instead of the network",
+	)
+	flag.IntVar(
+		&c.flags.concurrency,
+		"concurrency",
+		4,
+		"Number of raw pastes fetched in parallel per source",
+	)
+	flag.IntVar(
+		&c.flags.maxFetches,
+		"max-fetches",
+		0,
+		"Number of raw pastes fetched in parallel over all sources, shared by priority and weight (0 for no limit)",
+	)
+	flag.IntVar(
+		&c.flags.maxRequests,
+		"max-requests-per-cycle",
+		0,
+		"Maximum requests per cycle across all sources (0 for no limit)",
+	)
+	flag.StringVar(
+		&c.flags.prometheus,
+		"prometheus",
+		"",
+		"Address to serve Prometheus metrics on, e.g. :9100",
+	)
+	flag.StringVar(
+		&c.flags.statsd,
+		"statsd",
+		"",
+		"StatsD server to push metrics to, e.g. 127.0.0.1:8125",
+	)
+	flag.StringVar(
+		&c.flags.statsdPrefix,
+		"statsd-prefix",
+		"mailbot.",
+		"Prefix of StatsD metric names",
+	)
+	flag.StringVar(
+		&c.flags.statsdTags,
+		"statsd-tags",
+		"",
+		"Comma separated DogStatsD tags added to every metric, e.g. env:prod",
+	)
+	flag.BoolVar(
+		&c.flags.dogstatsd,
+		"dogstatsd",
+		false,
+		"Send StatsD metrics with DogStatsD tags",
+	)
+	flag.DurationVar(
+		&c.flags.statsdPush,
+		"statsd-interval",
+		10*time.Second,
+		"Interval between two StatsD pushes",
+	)
+	flag.DurationVar(
+		&c.flags.summary,
+		"summary-interval",
+		10*time.Minute,
+		"Interval between two activity summaries on stderr (0 to disable)",
+	)
+	flag.StringVar(
+		&c.flags.summaryFile,
+		"summary-file",
+		"",
+		"File to also append the activity summaries to",
+	)
+	flag.BoolVar(
+		&c.flags.tui,
+		"tui",
+		false,
+		"Show a live dashboard in the terminal",
+	)
+	flag.StringVar(
+		&c.flags.web,
+		"web",
+		"",
+		"Address to serve the web dashboard on, e.g. :8080 for 127.0.0.1:8080",
+	)
+	flag.StringVar(
+		&c.flags.webOrigins,
+		"web-origins",
+		"",
+		"Comma-separated origins, besides the dashboard's own, allowed to open /ws, e.g. https://ops.example.com",
+	)
+	flag.StringVar(
+		&c.flags.api,
+		"api",
+		"",
+		"Address to serve the control API on, e.g. 127.0.0.1:8081",
+	)
+	flag.StringVar(
+		&c.flags.apiToken,
+		"api-token",
+		"",
+		"Bearer token of the control API and gRPC services (default $MAILBOT_API_TOKEN)",
+	)
+	flag.StringVar(
+		&c.flags.grpc,
+		"grpc",
+		"",
+		"Address to serve the gRPC service on, e.g. 127.0.0.1:9090",
+	)
+	flag.StringVar(
+		&c.flags.events,
+		"events",
+		"",
+		"File to write operational events to as NDJSON",
+	)
+	flag.IntVar(
+		&c.flags.eventsFD,
+		"events-fd",
+		0,
+		"Inherited file descriptor to write operational events to, e.g. 3",
+	)
+	flag.IntVar(
+		&c.flags.maxErrors,
+		"max-consecutive-errors",
+		0,
+		"Exit with code 3 once every source failed this many cycles in a row (0 to never)",
+	)
+	flag.StringVar(
+		&c.flags.redis,
+		"redis",
+		"",
+		"Redis server to share the seen store and the work queue through, e.g. redis://127.0.0.1:6379/0",
+	)
+	flag.StringVar(
+		&c.flags.redisPrefix,
+		"redis-prefix",
+		"mailbot:",
+		"Prefix of the Redis keys used",
+	)
+	flag.BoolVar(
+		&c.flags.dispatch,
+		"dispatch",
+		false,
+		"Push the listed pastes to the -redis work queue for workers instead of fetching them",
+	)
+	flag.DurationVar(
+		&c.flags.leaderTTL,
+		"leader-ttl",
+		15*time.Second,
+		"Time the leadership of instances sharing -redis outlives its holder",
+	)
+	flag.IntVar(
+		&c.flags.fetchQueue,
+		"fetch-queue",
+		100,
+		"Size of the queue of URLs added through the control API and gRPC",
+	)
+	flag.IntVar(
+		&c.flags.extractQueue,
+		"extract-queue",
+		64,
+		"Size of the queue of fetched pages waiting for extraction",
+	)
+	flag.IntVar(
+		&c.flags.extractWorkers,
+		"extract-workers",
+		runtime.NumCPU(),
+		"Number of pages scanned for addresses in parallel",
+	)
+	flag.IntVar(
+		&c.flags.sinkQueue,
+		"sink-queue",
+		64,
+		"Size of the queue of extracted addresses waiting to be written",
+	)
+	flag.IntVar(
+		&c.flags.chunkSize,
+		"chunk-size",
+		1<<20,
+		"Scan pages larger than this many bytes in parallel chunks (0 to never)",
+	)
+	flag.StringVar(
+		&c.flags.maxMemory,
+		"max-memory",
+		"",
+		"Soft memory limit, e.g. 512M; queues shrink and caches are evicted near it",
+	)
+	flag.IntVar(
+		&c.flags.maxGoroutines,
+		"max-goroutines",
+		0,
+		"Don't start fetches while this many goroutines run (0 for no limit)",
+	)
+	flag.DurationVar(
+		&c.flags.shutdownWait,
+		"shutdown-timeout",
+		5*time.Second,
+		"Time given to running work to finish on shutdown",
+	)
+	flag.StringVar(
+		&c.flags.shard,
+		"shard",
+		"",
+		"Do only shard i of n of the work, e.g. 2/5",
+	)
+	flag.StringVar(
+		&c.flags.shardBy,
+		"shard-by",
+		shardBySource,
+		"Divide sources or pastes between the shards: source or paste",
 	)
 	flag.BoolVar(
-		&c.flags.slexy,
-		"slexy",
+		&c.flags.coordinator,
+		"coordinator",
+		false,
+		"Lease listed pastes to workers through the Coordinator service on -grpc",
+	)
+	flag.StringVar(
+		&c.flags.coordinatorURL,
+		"coordinator-url",
+		"",
+		"Coordinator a worker leases pastes from, e.g. http://10.0.0.1:9090",
+	)
+	flag.DurationVar(
+		&c.flags.leaseTTL,
+		"lease-ttl",
+		30*time.Second,
+		"Time a worker has to complete or renew a lease",
+	)
+	flag.StringVar(
+		&c.flags.audit,
+		"audit",
+		"",
+		"File to append a hash-chained log of every fetch and finding to",
+	)
+	flag.StringVar(
+		&c.flags.sentry,
+		"sentry-dsn",
+		"",
+		"Sentry DSN to report panics and failing sources to (default $SENTRY_DSN)",
+	)
+	flag.IntVar(
+		&c.flags.sentryFailures,
+		"sentry-failures",
+		3,
+		"Report a source to Sentry once it failed this many cycles in a row",
+	)
+	flag.IntVar(
+		&c.flags.maxResults,
+		"max-results",
+		0,
+		"Exit cleanly once this many new addresses were written (0 for no limit)",
+	)
+	flag.DurationVar(
+		&c.flags.maxRuntime,
+		"max-runtime",
+		0,
+		"Exit cleanly after running this long (0 for no limit)",
+	)
+	flag.StringVar(
+		&c.flags.smtp,
+		"smtp",
+		"",
+		"SMTP server to send digests through, e.g. smtp.example.com:587",
+	)
+	flag.StringVar(
+		&c.flags.smtpUser,
+		"smtp-user",
+		"",
+		"SMTP user name, the password is read from $MAILBOT_SMTP_PASSWORD",
+	)
+	flag.StringVar(
+		&c.flags.smtpFrom,
+		"smtp-from",
+		"",
+		"Sender address of digest emails",
+	)
+	flag.StringVar(
+		&c.flags.smtpTo,
+		"smtp-to",
+		"",
+		"Comma separated recipients of digest emails",
+	)
+	flag.StringVar(
+		&c.flags.smtpTLS,
+		"smtp-tls",
+		"starttls",
+		"SMTP transport security: starttls, tls or none",
+	)
+	flag.BoolVar(
+		&c.flags.smtpInsecure,
+		"smtp-insecure",
+		false,
+		"Don't verify the SMTP server's certificate",
+	)
+	flag.DurationVar(
+		&c.flags.digest,
+		"digest-interval",
+		24*time.Hour,
+		"Interval between two digest emails, e.g. 1h or 24h (0 to disable)",
+	)
+	flag.StringVar(
+		&c.flags.watchlist,
+		"watchlist",
+		"",
+		"File of addresses and domains to alert on, one per line",
+	)
+	flag.StringVar(
+		&c.flags.keywords,
+		"keywords",
+		"",
+		"File of keywords to alert on when a paste contains them, one per line",
+	)
+	flag.StringVar(
+		&c.flags.alerts,
+		"alerts",
+		"alerts.jsonl",
+		"File to write alerts to",
+	)
+	flag.StringVar(
+		&c.flags.webhook,
+		"notify-webhook",
+		"",
+		"URL to post alerts to as {\"text\": ...} JSON, e.g. a Slack webhook",
+	)
+	flag.StringVar(
+		&c.flags.sinkWebhook,
+		"sink-webhook",
+		"",
+		"URL to post findings to in batches as {\"findings\": [...]} JSON",
+	)
+	flag.StringVar(
+		&c.flags.elasticsearch,
+		"elasticsearch",
+		"",
+		"Elasticsearch URL to index findings to with the bulk API",
+	)
+	flag.StringVar(
+		&c.flags.elasticIndex,
+		"elasticsearch-index",
+		"mailbot",
+		"Elasticsearch index to write findings to",
+	)
+	flag.IntVar(
+		&c.flags.batchSize,
+		"batch-size",
+		100,
+		"Number of findings a sink gets at once",
+	)
+	flag.DurationVar(
+		&c.flags.batchInterval,
+		"batch-interval",
+		time.Second,
+		"Longest a finding waits for its batch to fill",
+	)
+	flag.IntVar(
+		&c.flags.sinkBacklog,
+		"sink-backlog",
+		16,
+		"Number of batches a sink may fall behind before they are spooled to disk",
+	)
+	flag.StringVar(
+		&c.flags.spool,
+		"spool",
+		"mailbot-spool",
+		"Directory keeping the findings sinks couldn't take until they can",
+	)
+	flag.BoolVar(
+		&c.flags.selftestServer,
+		"selftest-server",
+		false,
+		"Crawl synthetic pastes served by a built-in mock site instead of the real sources",
+	)
+	flag.Float64Var(
+		&c.flags.selftestRate,
+		"selftest-rate",
+		10,
+		"Pastes posted per second per source by -selftest-server",
+	)
+	flag.IntVar(
+		&c.flags.selftestEmails,
+		"selftest-emails",
+		5,
+		"Addresses in each -selftest-server paste",
+	)
+	flag.IntVar(
+		&c.flags.selftestSize,
+		"selftest-size",
+		4096,
+		"Size in bytes of each -selftest-server paste",
+	)
+	flag.DurationVar(
+		&c.flags.selftestDelay,
+		"selftest-latency",
+		0,
+		"Delay before -selftest-server answers a request",
+	)
+	flag.IntVar(
+		&c.flags.selftestLimit,
+		"selftest-limit",
+		0,
+		"Requests per second per source -selftest-server answers before replying 429 (0 for no limit)",
+	)
+	flag.BoolVar(
+		&c.flags.checkMX,
+		"check-mx",
 		true,
-		"Crawl slexy.org",
+		"Look up the mail servers of each domain in mailbot validate",
+	)
+	flag.StringVar(
+		&c.flags.disposable,
+		"disposable",
+		"",
+		"File of disposable mail domains, one per line, adding to the built-in ones",
+	)
+	flag.BoolVar(
+		&c.flags.json,
+		"json",
+		false,
+		"Write reports as JSON",
+	)
+	flag.IntVar(
+		&c.flags.statsTop,
+		"top",
+		10,
+		"Number of domains and TLDs listed by mailbot stats",
+	)
+	flag.DurationVar(
+		&c.flags.statsBucket,
+		"bucket",
+		24*time.Hour,
+		"Period mailbot stats counts new addresses per",
+	)
+	flag.StringVar(
+		&c.flags.exportFormat,
+		"format",
+		"jsonl",
+		"Format mailbot export writes: "+strings.Join(exportFormats, ", "),
+	)
+	flag.StringVar(
+		&c.flags.since,
+		"since",
+		"",
+		"Only take pastes posted since then, e.g. 2024-05-01, \"2024-05-01 12:00\" or 48h (ago), as far as the archives tell",
+	)
+	flag.StringVar(
+		&c.flags.until,
+		"until",
+		"",
+		"Only take pastes posted before then, in the same forms as -since",
+	)
+	flag.BoolVar(
+		&c.flags.provenance,
+		"provenance",
+		false,
+		"Follow every address in the output file and on stdout with a # comment saying where and when it was found",
+	)
+	flag.StringVar(
+		&c.flags.campaign,
+		"campaign",
+		"",
+		"Label the findings of this run with, kept apart from those of other campaigns; selects the findings mailbot stats and export read",
+	)
+	flag.BoolVar(
+		&c.flags.compliance,
+		"compliance",
+		false,
+		"Crawl responsibly: implies -robots, -redact hash, -retention 720h, -provenance and -audit, and at most one request every 5s per site",
+	)
+	flag.BoolVar(
+		&c.flags.robots,
+		"robots",
+		false,
+		"Fetch nothing the sites' robots.txt disallows, and honor their Crawl-delay",
+	)
+	flag.StringVar(
+		&c.flags.redact,
+		"redact",
+		"",
+		"Write addresses as their SHA-256 (hash) or with the local part masked (mask) instead of in clear",
+	)
+	flag.DurationVar(
+		&c.flags.retention,
+		"retention",
+		0,
+		"Remove findings, alerts and dead letters older than this from the files mailbot keeps (0 to keep them)",
+	)
+	flag.StringVar(
+		&c.flags.suppress,
+		"suppress",
+		"mailbot-suppress.txt",
+		"File of addresses and domains never to collect, as added by mailbot suppress",
+	)
+	flag.IntVar(
+		&c.flags.driftCycles,
+		"drift-cycles",
+		3,
+		"Cycles in a row a source's archive must list no paste before an alert says its layout changed (0 to never alert)",
+	)
+	flag.StringVar(
+		&c.flags.updateURL,
+		"update-url",
+		releaseURL,
+		"Release endpoint mailbot self-update fetches the newest release from",
+	)
+	flag.StringVar(
+		&c.flags.updateKey,
+		"update-key",
+		releaseKey,
+		"Base64 ed25519 public key the releases mailbot self-update installs must be signed with",
+	)
+	flag.BoolVar(
+		&c.flags.force,
+		"force",
+		false,
+		"Have mailbot self-update install the latest release even if it isn't newer than the one running",
 	)
+	flag.BoolVar(
+		&c.flags.probe,
+		"probe",
+		false,
+		"Connect to the servers the config names in mailbot config check",
+	)
+	flag.BoolVar(
+		&c.flags.companion,
+		"companion",
+		false,
+		"If another instance holds the lock on the output, follow what it writes read-only instead of exiting",
+	)
+	flag.StringVar(
+		&c.flags.config,
+		"config",
+		"",
+		"JSON config file with per-source settings",
+	)
+}
 
+func main() {
+	c.startLifecycle()
 	flag.Parse()
+	// Flags may follow the command too, e.g. "mailbot retry -v"
+	if flag.NArg() > 0 {
+		c.command = flag.Arg(0)
+		flag.CommandLine.Parse(flag.Args()[1:])
+		if c.command == "config" && flag.Arg(0) == "check" {
+			// And "mailbot config check -probe"
+			c.command = "config check"
+			flag.CommandLine.Parse(flag.Args()[1:])
+		}
+	}
+	if c.command == "init" {
+		c.Init(flag.Arg(0))
+		return
+	}
+	// First, as its options set flags
+	if err := c.loadConfig(c.flags.config); err != nil {
+		reportFatal(err)
+		os.Exit(exitConfig)
+	}
+	if err := c.applyCompliance(); err != nil {
+		reportFatal(err)
+		os.Exit(exitConfig)
+	}
+	for level, on := range c.flags.verbose {
+		if on {
+			c.verbosity = level
+		}
+	}
+	if c.flags.pretty && !isTerminal(os.Stdout) {
+		c.flags.pretty = false
+	}
+	if c.command == "config check" {
+		c.ConfigCheck()
+		return
+	}
+	if c.command == "self-update" {
+		c.SelfUpdate()
+		return
+	}
+
+	if err := c.setup(); err != nil {
+		reportFatal(c.config.explain(err))
+		os.Exit(exitConfig)
+	}
+	if err := c.checkModes(); err != nil {
+		reportFatal(c.config.explain(err))
+		os.Exit(exitConfig)
+	}
+	if err := c.openEvents(); err != nil {
+		reportFatal(err)
+		os.Exit(exitConfig)
+	}
+	if dsn := c.sentryDSN(); dsn != "" {
+		var err error
+		if c.sentry, err = newSentry(dsn); err != nil {
+			reportFatal(err)
+			os.Exit(exitConfig)
+		}
+		defer c.capturePanic(nil, "")
+	}
+	if c.flags.smtp != "" {
+		var err error
+		c.mailer, err = c.newSMTPNotifier()
+		if err != nil {
+			reportFatal(err)
+			os.Exit(exitConfig)
+		}
+		c.notifiers = append(c.notifiers, c.queueNotifier("smtp", c.mailer))
+	}
+	if c.flags.webhook != "" {
+		c.notifiers = append(c.notifiers, c.queueNotifier("webhook", &webhookNotifier{
+			url:    c.flags.webhook,
+			client: &http.Client{Timeout: c.notifyTimeout()},
+		}))
+	}
+	if err := c.loadSuppressions(); err != nil {
+		reportFatal(err)
+		os.Exit(exitConfig)
+	}
+	if c.flags.watchlist != "" {
+		var err error
+		if c.watchlist, err = loadWatchlist(c.flags.watchlist); err != nil {
+			reportFatal(err)
+			os.Exit(exitConfig)
+		}
+	}
+	if c.flags.keywords != "" {
+		var err error
+		if c.keywords, err = loadKeywords(c.flags.keywords); err != nil {
+			reportFatal(err)
+			os.Exit(exitConfig)
+		}
+	}
+	if c.flags.dispatch && c.flags.redis == "" {
+		reportFatal(errors.New("-dispatch needs -redis"))
+		os.Exit(exitConfig)
+	}
+	if c.flags.redis != "" {
+		if err := c.setupRedis(); err != nil {
+			reportFatal(err)
+			os.Exit(exitConfig)
+		}
+	}
+	if c.flags.coordinator {
+		c.startCoordinator()
+	}
+	if c.flags.api != "" || c.flags.grpc != "" || c.flags.web != "" || c.flags.coordinatorURL != "" {
+		if _, err := c.apiToken(); err != nil {
+			reportFatal(err)
+			os.Exit(exitConfig)
+		}
+	}
+
+	switch c.command {
+	case "":
+		if err := c.lockOutput(); err != nil {
+			if _, ok := err.(*lockedError); !ok || !c.flags.companion {
+				reportFatal(err)
+				os.Exit(exitLocked)
+			}
+			c.logf(0, "%v, following it", err)
+			c.Companion()
+		}
+		c.open()
+		c.Run()
+	case "retry":
+		c.open()
+		c.Retry()
+	case "bench":
+		c.Bench(flag.Arg(0))
+	case "validate":
+		c.Validate(flag.Arg(0))
+	case "stats":
+		c.Stats(flag.Args())
+	case "export":
+		c.Export(flag.Args())
+	case "check-sources":
+		c.CheckSources()
+	case "suppress":
+		c.Suppress(flag.Args())
+	case "config":
+		reportFatal(errors.New("usage: mailbot config check [-probe] [flags]"))
+		os.Exit(exitConfig)
+	case "worker":
+		if c.flags.coordinatorURL == "" {
+			c.open()
+		}
+		c.Work()
+	default:
+		reportFatal(fmt.Errorf("unknown command %q", c.command))
+		os.Exit(exitConfig)
+	}
+}
 
-	c.file, err = os.OpenFile(
+// open takes the lock on the output and opens it and the alerts and audit
+// files, remembering the addresses the output already holds
+func (c *Crawler) open() {
+	c.mustLock()
+	// Torn lines a killed run left behind
+	for _, path := range []string{c.flags.filename, c.flags.alerts, c.flags.deadletter} {
+		if path == "" {
+			continue
+		}
+		if err := recoverLines(path); err != nil {
+			reportFatal(err)
+			os.Exit(exitSink)
+		}
+	}
+	if c.flags.audit != "" {
+		if err := c.openAudit(c.flags.audit); err != nil {
+			reportFatal(err)
+			os.Exit(exitSink)
+		}
+	}
+	if (c.watchlist != nil || c.keywords != nil) && c.flags.alerts != "" {
+		f, err := os.OpenFile(c.flags.alerts, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+		if err != nil {
+			reportFatal(err)
+			os.Exit(exitSink)
+		}
+		c.alerts.out = f
+	}
+	c.store = c.newStore()
+	err := c.preload(c.flags.filename)
+	if err != nil {
+		reportFatal(err)
+		os.Exit(exitSink)
+	}
+	file, err := os.OpenFile(
 		c.flags.filename,
 		os.O_APPEND|os.O_WRONLY|os.O_CREATE,
 		0600,
 	)
 	if err != nil {
-		report(err)
+		reportFatal(err)
+		os.Exit(exitSink)
+	}
+	if err := c.openSinks(file); err != nil {
+		reportFatal(err)
+		os.Exit(exitConfig)
+	}
+	c.startPipeline()
+	if c.flags.retention > 0 {
+		c.spawn("retention", c.pruneRetention)
+	}
+	if c.flags.maxMemory != "" {
+		limit, _ := parseSize(c.flags.maxMemory)
+		c.spawn("memory limit", func() { c.limitMemory(limit) })
 	}
 }
 
-func main() {
-	c.Run()
+// setup prepares the HTTP client of every source
+func (c *Crawler) setup() error {
+	if c.flags.record != "" && c.flags.replay != "" {
+		return errors.New("-record and -replay are mutually exclusive")
+	}
+	if c.flags.maxGoroutines != 0 && c.flags.maxGoroutines < minGoroutines {
+		return fmt.Errorf("-max-goroutines must be 0 or at least %d", minGoroutines)
+	}
+	if c.flags.maxMemory != "" {
+		if _, err := parseSize(c.flags.maxMemory); err != nil {
+			return fmt.Errorf("-max-memory: %v", err)
+		}
+	}
+	if c.flags.fetchQueue < 1 || c.flags.extractQueue < 1 || c.flags.sinkQueue < 1 || c.flags.sinkBacklog < 1 || c.flags.extractWorkers < 1 {
+		return errors.New("queue sizes, -sink-backlog and -extract-workers must be at least 1")
+	}
+	if c.flags.network.Jitter < 0 || c.flags.network.Jitter > 1 {
+		return errors.New("-jitter must be between 0 and 1")
+	}
+	if c.flags.selftestServer {
+		if c.flags.replay != "" || c.flags.record != "" {
+			return errors.New("-selftest-server can't be used with -record or -replay")
+		}
+		if err := c.startSelftest(); err != nil {
+			return err
+		}
+	}
+	if c.flags.redact != "" && c.flags.redact != redactHash && c.flags.redact != redactMask {
+		return fmt.Errorf("-redact must be %s or %s", redactHash, redactMask)
+	}
+	if c.flags.retention < 0 {
+		return errors.New("-retention must not be negative")
+	}
+	if c.flags.maxFetches < 0 {
+		return errors.New("-max-fetches must not be negative")
+	}
+	c.sched.limit = c.flags.maxFetches
+	for _, s := range sources {
+		s.network = c.network(s)
+		if s.network.Jitter < 0 || s.network.Jitter > 1 {
+			return fmt.Errorf("%s: jitter must be between 0 and 1", s.Name)
+		}
+		s.concurrency = c.flags.concurrency
+		if sc, ok := c.config.Sources[s.Name]; ok && sc.Concurrency != nil {
+			s.concurrency = *sc.Concurrency
+		}
+		if s.concurrency < 1 {
+			return fmt.Errorf("%s: concurrency must be at least 1", s.Name)
+		}
+		if sc, ok := c.config.Sources[s.Name]; ok && sc.MaxRequests != nil {
+			s.maxRequests = *sc.MaxRequests
+		}
+		s.weight = 1
+		if sc, ok := c.config.Sources[s.Name]; ok {
+			if sc.Priority != nil {
+				s.priority = *sc.Priority
+			}
+			if sc.Weight != nil {
+				s.weight = *sc.Weight
+			}
+		}
+		if s.weight < 1 {
+			return fmt.Errorf("%s: weight must be at least 1", s.Name)
+		}
+		s.campaign = c.flags.campaign
+		if sc, ok := c.config.Sources[s.Name]; ok && sc.Campaign != nil {
+			s.campaign = *sc.Campaign
+		}
+		if s.campaign != "" && !validCampaign.MatchString(s.campaign) {
+			return fmt.Errorf("%s: campaign %q may only hold letters, digits, '.', '_' and '-'", s.Name, s.campaign)
+		}
+		if c.flags.compliance {
+			c.conservative(s)
+		}
+		if c.fetcher != nil {
+			// Set in place of the network, as by tests
+			s.fetcher = c.fetcher
+			continue
+		}
+		client, err := newClient(s.network)
+		if err != nil {
+			return fmt.Errorf("%s: %v", s.Name, err)
+		}
+		client.Transport, err = c.fixtures(client.Transport)
+		if err != nil {
+			return err
+		}
+		if c.selftest != nil {
+			client.Transport = &selftestTransport{c.selftest.addr, client.Transport}
+		}
+		s.fetcher = &httpFetcher{client}
+	}
+	if err := c.setupPolling(); err != nil {
+		return err
+	}
+	if err := c.setupWindow(); err != nil {
+		return err
+	}
+	return c.setupShard()
 }
 
 // Run runs the crawler
 func (c *Crawler) Run() {
-	var wg = &sync.WaitGroup{}
-	for {
-		if c.flags.pastebin {
-			wg.Add(1)
-			go c.Pastebin(wg)
+	if c.flags.prometheus != "" {
+		c.servePrometheus(c.flags.prometheus)
+	}
+	if c.flags.statsd != "" {
+		c.spawn("statsd", func() {
+			c.pushStatsd(
+				c.flags.statsd,
+				c.flags.statsdPrefix,
+				c.flags.statsdTags,
+				c.flags.dogstatsd,
+				c.flags.statsdPush,
+			)
+		})
+	}
+	if c.flags.web != "" {
+		token, _ := c.apiToken()
+		c.serveWeb(c.flags.web, token)
+	}
+	c.queue = make(chan queued, c.flags.fetchQueue)
+	c.wake = make(chan struct{}, 1)
+	c.spawn("fetch queue", c.drainQueue)
+	if c.flags.api != "" {
+		token, _ := c.apiToken()
+		c.serveAPI(c.flags.api, token)
+	}
+	if c.flags.grpc != "" {
+		token, _ := c.apiToken()
+		c.serveGRPC(c.flags.grpc, token)
+	}
+	if c.flags.tui {
+		c.flags.printToStdout = false
+		c.flags.pretty = false
+		c.startTUI()
+	}
+	if c.flags.summary > 0 {
+		c.spawn("summary", func() { c.summarize(c.flags.summary, c.flags.summaryFile) })
+	}
+	if c.redis != nil {
+		c.spawn("leader election", c.elect)
+	}
+	if c.mailer != nil && c.flags.digest > 0 {
+		c.spawn("digest", func() { c.sendDigests(c.mailer, c.flags.digest) })
+	}
+	c.handleSignals()
+	if c.flags.maxRuntime > 0 {
+		time.AfterFunc(c.flags.maxRuntime, func() {
+			c.shutdown("max runtime reached", exitOK)
+		})
+	}
+	var (
+		wg       = &sync.WaitGroup{}
+		adaptive = c.adaptivePolling()
+		forced   = true
+	)
+	for cycle := 1; c.ctx.Err() == nil; cycle++ {
+		c.resetBudget()
+		var crawled []string
+		now := time.Now()
+		for _, s := range sources {
+			if s.enabled && !s.isPaused() && atomic.LoadInt32(&c.paused) == 0 && (!adaptive || forced || s.due(now)) {
+				crawled = append(crawled, s.Name)
+			}
 		}
-		if c.flags.debian {
-			wg.Add(1)
-			go c.Debian(wg)
+		forced = false
+		if len(crawled) == 0 {
+			// Woken before any source is due
+			cycle--
+			c.wait(c.untilDue(time.Now()), &forced)
+			continue
 		}
-		if c.flags.slexy {
+		c.emit(eventCycleStart, map[string]interface{}{"cycle": cycle, "sources": crawled})
+		start, began := c.metrics.snapshot(), time.Now()
+		stop := c.progress(c.cycleProgress(cycle, start))
+		c.beginCycle(len(crawled))
+		for _, name := range crawled {
 			wg.Add(1)
-			go c.Slexy(wg)
+			s := lookupSource(name)
+			c.spawn("crawl "+name, func() { c.Crawl(s, wg) })
 		}
 		wg.Wait()
+		c.drain()
+		stop()
+		if adaptive {
+			snap := c.metrics.snapshot()
+			for _, name := range crawled {
+				listed := snap[metricKey{metricListed, name}] - start[metricKey{metricListed, name}]
+				seen := snap[metricKey{metricSkipped, name}] - start[metricKey{metricSkipped, name}]
+				c.adapt(lookupSource(name), listed, listed-seen)
+			}
+		}
+		c.printCycle(cycle, start, time.Since(began))
+		if c.allFailing() {
+			reportFatal(fmt.Errorf("every source failed %d cycles in a row", c.flags.maxErrors))
+			c.shutdown("all sources failing", exitSourcesFailing)
+		}
+		if adaptive {
+			c.wait(c.untilDue(time.Now()), &forced)
+		} else {
+			c.wait(jitter(c.flags.interval, c.network(nil).Jitter), &forced)
+		}
+	}
+	// shutdown exits once the other goroutines are done
+	select {}
+}
+
+// wait waits d for the next cycle, or until one is asked for, which sets
+// forced
+func (c *Crawler) wait(d time.Duration, forced *bool) {
+	select {
+	case <-time.After(d):
+	case <-c.wake:
+		*forced = true
+	case <-c.ctx.Done():
 	}
 }
 
-// GetMail extracts email addresses from text documents
-func (c *Crawler) GetMail(page string) {
-	r := regexp.MustCompile(`[\w]+@[\w.]+`)
-	mails := r.FindAllString(page, -1)
+// GetMail queues a text document just fetched from url for email
+// extraction. It blocks while the extract queue is full.
+func (c *Crawler) GetMail(s *Source, url string, body []byte) {
+	fetched := time.Now()
+	c.pipeline.add()
+	c.pipeline.pages <- fetchedPage{s, url, body, fetched}
+}
+
+// collect queues the addresses extracted elsewhere from url for the sink.
+// sum is the SHA-256 of the page they were found in, for the audit log.
+func (c *Crawler) collect(s *Source, url, sum string, mails []string) {
 	if mails == nil {
-		if c.flags.verbose {
-			report(
-				errors.New("no mail found"),
-			)
-		}
 		return
 	}
-	fresh := FreshFilter(mails)
-	if len(fresh) != 0 {
+	c.pipeline.add()
+	c.pipeline.batches <- batch{s, url, sum, mails, time.Now()}
+}
+
+// write writes the addresses of b that weren't seen before
+func (c *Crawler) write(b batch) {
+	s, url, sum := b.source, b.url, b.sum
+	fresh, duplicates := c.dedup(s.campaign, c.unsuppressedMails(b.mails))
+	c.count(metricDuplicates, s.Name, int64(duplicates))
+	fresh = c.limitResults(s, fresh)
+	if len(fresh) == 0 {
 		return
 	}
-	toWrite := strings.Join(fresh, "\n")
-	c.file.WriteString(toWrite + "\n")
+	c.count(metricEmails, s.Name, int64(len(fresh)))
+	now := time.Now()
+	findings := make([]Finding, len(fresh))
+	emitted := make([]string, len(fresh))
+	for i, mail := range fresh {
+		f := Finding{Email: c.redact(mail), Source: s.Name, URL: url, Time: b.fetched, Campaign: s.campaign}
+		findings[i] = f
+		emitted[i] = f.Email
+		if c.flags.provenance {
+			emitted[i] += provenanceSuffix(f)
+		}
+		c.record(auditRecord{Time: now, Type: "finding", Source: s.Name, URL: url, Email: f.Email, SHA256: sum, Campaign: s.campaign})
+		c.recent.add(f)
+		c.broker.publish(f)
+		if c.mailer != nil {
+			c.addDigest(f)
+		}
+		c.watch(f, mail)
+	}
 	if c.flags.printToStdout {
-		fmt.Println(toWrite)
+		c.mu.Lock()
+		c.printFindings(s, emitted)
+		c.mu.Unlock()
 	}
-	c.file.Sync()
-	return
+	c.sink(findings)
 }
 
-// FetchPage fetches/scrapes pages from web URLsl
-func (c *Crawler) FetchPage(url string) (string, error) {
-	if c.flags.verbose {
-		fmt.Printf("Fetching: %s\n", url)
-	}
-	client := &http.Client{}
-	resp, err := client.Get(url)
-	if err != nil {
-		report(err)
-		return "", err
+// FetchPage fetches/scrapes pages from web URLs, retrying failed attempts
+// with exponential backoff. URLs that fail every attempt are dead-lettered.
+func (c *Crawler) FetchPage(s *Source, url string) ([]byte, error) {
+	var (
+		page  []byte
+		err   error
+		retry bool
+		first = time.Now()
+	)
+	attempts := 0
+	for attempts <= s.network.Retries {
+		if attempts > 0 {
+			backoff := jitter(s.network.RetryBackoff<<uint(attempts-1), s.network.Jitter)
+			atomic.StoreInt64(&s.backoffUntil, time.Now().Add(backoff).UnixNano())
+			if !c.sleep(backoff) {
+				return nil, c.ctx.Err()
+			}
+		}
+		attempts++
+		page, retry, err = c.fetch(s, url)
+		if err == nil {
+			return page, nil
+		}
+		if err == errBudget || err == errRobots || c.ctx.Err() != nil {
+			// Not the URL's fault, so not dead-lettered
+			return nil, err
+		}
+		if !retry {
+			break
+		}
+		if attempts <= s.network.Retries {
+			c.logf(levelInfo, "%v, retrying", err)
+		}
 	}
-	b, err := ioutil.ReadAll(resp.Body)
-	return string(b), err
+	c.DeadLetter(s, url, err, first, attempts)
+	return nil, err
 }
 
-// Pastebin collects emails from pastebin.com
-func (c *Crawler) Pastebin(wg *sync.WaitGroup) {
-	defer wg.Done()
-	r := regexp.MustCompile(`class="i_p0" alt="" /><a href="(.*?)">`)
-	url := "https://pastebin.com/archive"
-	page, err := c.FetchPage(url)
-	if err != nil {
-		report(err)
+// fetch makes a single attempt at url. retry reports whether a failure is
+// worth another attempt.
+func (c *Crawler) fetch(s *Source, url string) (page []byte, retry bool, err error) {
+	if c.flags.robots && !c.allowedByRobots(s, url) {
+		c.logf(levelInfo, "%s: %v", url, errRobots)
+		return nil, false, errRobots
 	}
-	raws := r.FindAllString(page, -1)
-	if raws == nil {
-		if c.flags.verbose {
-			report(errors.New("no raw link"))
-		}
-		return
+	if !c.spend(s) {
+		return nil, false, errBudget
 	}
-	for _, v := range raws {
-		parser := strings.Split(v, `="`)
-		if len(parser) < 4 {
-			report(errors.New("can't parse"))
-			return
-		}
-		rawlink := "https://pastebin.com/raw" + strings.Replace(parser[3], `">`, "", -1)
-		page, err := c.FetchPage(rawlink)
+	s.throttle()
+	var (
+		start  = time.Now()
+		status int
+		body   []byte
+	)
+	defer func() {
+		c.count(metricRequests, s.Name, 1)
 		if err != nil {
-			report(err)
-			return
+			c.count(metricRequestErrors, s.Name, 1)
 		}
-		c.GetMail(page)
+		c.auditFetch(s, url, start, status, body, err)
+	}()
+	c.logf(levelFetch, "Fetching: %s", url)
+	header := make(http.Header)
+	for k, v := range s.network.Headers {
+		header.Set(k, v)
 	}
-
-}
-
-// Debian collects emails from paste.debian.net
-func (c *Crawler) Debian(wg *sync.WaitGroup) {
-	defer wg.Done()
-	r := regexp.MustCompile(`<li><a href='//paste.debian.net(.*?)'>`)
-	url := "http://paste.debian.net"
-	page, err := c.FetchPage(url)
+	resp, err := s.fetcher.Fetch(c.ctx, url, header)
 	if err != nil {
-		report(err)
+		return nil, true, err
 	}
-	raws := r.FindAllString(page, -1)
-	if raws == nil {
-		if c.flags.verbose {
-			report(errors.New("no raw link"))
-		}
-		return
+	status = resp.StatusCode
+	if resp.StatusCode == http.StatusTooManyRequests {
+		c.emit(eventThrottled, map[string]interface{}{"source": s.Name, "url": url, "reason": "http 429"})
 	}
-	for _, v := range raws {
-		parser := strings.Split(v, `<li><a href='//`)
-		if len(parser) < 2 {
-			report(errors.New("can't parse"))
-			return
-		}
-		rawlink := "http://" + strings.Replace(parser[1], `'>`, "", -1)
-		page, err := c.FetchPage(rawlink)
-		if err != nil {
-			report(err)
-			return
-		}
-		c.GetMail(page)
+	if resp.StatusCode != http.StatusOK {
+		retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return nil, retry, fmt.Errorf("%s: %s", url, resp.Status)
 	}
+	body = resp.Body
+	c.logf(levelDebug, "%s: %s, %d bytes", url, resp.Status, len(body))
+	atomic.StoreInt64(&s.lastSuccess, time.Now().UnixNano())
+	return body, false, nil
+}
 
+// Verbosity levels
+const (
+	levelInfo  = 1 // -v
+	levelFetch = 2 // -vv
+	levelDebug = 3 // -vvv
+)
+
+// logf reports a diagnostic message when the verbosity is at least level
+func (c *Crawler) logf(level int, format string, args ...interface{}) {
+	if c.verbosity >= level {
+		report(fmt.Errorf(format, args...))
+	}
 }
 
-// Slexy collects emails from slexy.org
-func (c *Crawler) Slexy(wg *sync.WaitGroup) {
-	defer wg.Done()
-	r := regexp.MustCompile(`\/view(.*?)">`)
-	url := "http://slexy.org/recent"
-	page, err := c.FetchPage(url)
-	if err != nil {
-		report(err)
+// report prints err to stderr, unless -quiet
+func report(err error) {
+	if !c.flags.quiet {
+		reportFatal(err)
 	}
-	raws := r.FindAllString(page, -1)
-	if raws == nil {
-		if c.flags.verbose {
-			report(errors.New("no raw link"))
-		}
+}
+
+// reportFatal prints err, on which mailbot exits with a failure code, to
+// stderr even with -quiet
+func reportFatal(err error) {
+	if c.tui != nil {
+		c.tui.log(err)
 		return
 	}
-	for _, v := range raws {
-		parser := strings.Split(v, `/view`)
-		if len(parser) < 2 {
-			report(errors.New("can't parse"))
-			return
-		}
-		rawlink := "http://slexy.org/raw" + strings.Replace(parser[1], `">`, "", -1)
-		page, err := c.FetchPage(rawlink)
-		if err != nil {
-			report(err)
-			return
-		}
-		c.GetMail(page)
+	if c.flags.pretty && isTerminal(os.Stderr) {
+		// Don't leave the message behind the progress line
+		fmt.Fprintf(os.Stderr, "%s\x1b[31m%v%s\n", ansiClear, err, ansiReset)
+		return
 	}
-}
-
-func report(err error) {
 	fmt.Fprintln(os.Stderr, err)
 }
 
@@ -260,33 +1485,7 @@ func report(err error) {
 func FreshFilter(mails []string) []string {
 	var fresh []string
 	for _, mail := range mails {
-		var blocked bool
-
-		if strings.Contains(mail, ".png") {
-			continue
-		}
-		if strings.Contains(mail, ".gif") {
-			continue
-		}
-		if strings.Contains(mail, ".jpg") {
-			continue
-		}
-		if strings.Contains(mail, "._") {
-			continue
-		}
-		if strings.Contains(mail, "@.") {
-			continue
-		}
-		if !strings.Contains(mail, ".") {
-			continue
-		}
-		for _, black := range blacklist {
-			if mail == black {
-				fmt.Println(mail, black)
-				blocked = true
-			}
-		}
-		if !blocked {
+		if freshMail([]byte(mail)) {
 			fresh = append(fresh, mail)
 		}
 	}
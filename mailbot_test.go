@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestGetMail(t *testing.T) {
+	setupTest(t)
+	s := lookupSource("slexy")
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"new addresses", "dan@example.com, erin@example.com", []string{"dan@example.com", "erin@example.com"}},
+		{"all seen", "dan@example.com", nil},
+		{"some new", "erin@example.com frank@example.com", []string{"frank@example.com"}},
+		{"invalid", "dan@localhost", nil},
+	}
+	for _, tt := range tests {
+		before := readOutput(t)
+		c.GetMail(s, "http://slexy.org/raw/"+tt.name, []byte(tt.body))
+		c.drain()
+		c.closeSinks()
+
+		got := readOutput(t)[len(before):]
+		sort.Strings(got)
+		if len(got) == 0 {
+			got = nil
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: wrote %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
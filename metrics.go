@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Core metrics, counted per source
+const (
+	metricListed        = "listed"
+	metricSkipped       = "skipped"
+	metricQueued        = "queued"
+	metricRequests      = "requests"
+	metricRequestErrors = "request_errors"
+	metricPastes        = "pastes"
+	metricEmails        = "emails"
+	metricDuplicates    = "duplicates"
+)
+
+var metricHelp = map[string]string{
+	metricListed:        "Pastes found on archive pages",
+	metricSkipped:       "Listed pastes skipped as already fetched",
+	metricQueued:        "Listed pastes handed to workers",
+	metricRequests:      "HTTP requests made",
+	metricRequestErrors: "HTTP requests that failed",
+	metricPastes:        "Raw pastes scanned",
+	metricEmails:        "Email addresses written",
+	metricDuplicates:    "Email addresses dropped as already seen",
+}
+
+type metricKey struct {
+	name   string
+	source string
+}
+
+// Metrics holds the crawler's counters
+type Metrics struct {
+	mu       sync.Mutex
+	counters map[metricKey]int64
+}
+
+// count adds n to the counter name of source
+func (c *Crawler) count(name, source string, n int64) {
+	m := &c.metrics
+	m.mu.Lock()
+	if m.counters == nil {
+		m.counters = make(map[metricKey]int64)
+	}
+	m.counters[metricKey{name, source}] += n
+	m.mu.Unlock()
+}
+
+// snapshot returns a copy of all counters
+func (m *Metrics) snapshot() map[metricKey]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snap := make(map[metricKey]int64, len(m.counters))
+	for k, v := range m.counters {
+		snap[k] = v
+	}
+	return snap
+}
+
+// sortedKeys returns the keys of snap ordered by name, then source
+func sortedKeys(snap map[metricKey]int64) []metricKey {
+	keys := make([]metricKey, 0, len(snap))
+	for k := range snap {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].source < keys[j].source
+	})
+	return keys
+}
+
+// serveMetrics writes the counters and the per-source gauges in the
+// Prometheus text format
+func (c *Crawler) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	snap := c.metrics.snapshot()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	var last string
+	for _, k := range sortedKeys(snap) {
+		name := "mailbot_" + k.name + "_total"
+		if k.name != last {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, metricHelp[k.name], name)
+			last = k.name
+		}
+		fmt.Fprintf(w, "%s{source=%q} %d\n", name, k.source, snap[k])
+	}
+
+	stats := c.sourceStats()
+	fmt.Fprint(w, "# HELP mailbot_error_ratio Share of the source's requests that failed\n# TYPE mailbot_error_ratio gauge\n")
+	for _, st := range stats {
+		fmt.Fprintf(w, "mailbot_error_ratio{source=%q} %g\n", st.Source, st.ErrorRate)
+	}
+	fmt.Fprint(w, "# HELP mailbot_last_success_timestamp_seconds Time of the source's last successful request\n# TYPE mailbot_last_success_timestamp_seconds gauge\n")
+	for _, st := range stats {
+		if st.LastSuccess != nil {
+			fmt.Fprintf(w, "mailbot_last_success_timestamp_seconds{source=%q} %d\n", st.Source, st.LastSuccess.Unix())
+		}
+	}
+	fmt.Fprint(w, "# HELP mailbot_poll_interval_seconds Delay between two crawls of the source\n# TYPE mailbot_poll_interval_seconds gauge\n")
+	for _, s := range sources {
+		if s.enabled {
+			fmt.Fprintf(w, "mailbot_poll_interval_seconds{source=%q} %g\n", s.Name, s.pollInterval().Seconds())
+		}
+	}
+	leader := 0
+	if c.isLeader() {
+		leader = 1
+	}
+	fmt.Fprintf(w, "# HELP mailbot_leader Whether the instance does the singleton duties of its fleet\n# TYPE mailbot_leader gauge\nmailbot_leader %d\n", leader)
+}
+
+// servePrometheus serves the metrics on addr until it fails. The JSON
+// per-source statistics are served on /stats next to them.
+func (c *Crawler) servePrometheus(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", c.serveMetrics)
+	mux.HandleFunc("/stats", c.serveStats)
+	c.listen("prometheus", &http.Server{Addr: addr, Handler: mux})
+}
+
+// statsdFloat formats v for StatsD, which doesn't always take exponents
+func statsdFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// pushStatsd sends the counter increments since the previous push, and the
+// gauges served on /metrics, to a statsd server every interval. With
+// dogstatsd the source and the configured tags are sent as tags, otherwise
+// the source is folded into the metric name.
+func (c *Crawler) pushStatsd(addr, prefix, tags string, dogstatsd bool, interval time.Duration) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		report(err)
+		return
+	}
+	defer conn.Close()
+
+	var extra string
+	if tags = strings.TrimSpace(tags); tags != "" {
+		extra = "," + tags
+	}
+	sent := make(map[metricKey]int64)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for c.tick(t) {
+		var buf bytes.Buffer
+		// send adds a metric of the given type for source, "" for the
+		// whole instance
+		send := func(name, source, value, typ string) {
+			var line string
+			switch {
+			case dogstatsd && source != "":
+				line = fmt.Sprintf("%s%s:%s|%s|#source:%s%s\n", prefix, name, value, typ, source, extra)
+			case dogstatsd && extra != "":
+				line = fmt.Sprintf("%s%s:%s|%s|#%s\n", prefix, name, value, typ, extra[1:])
+			case source != "":
+				line = fmt.Sprintf("%s%s.%s:%s|%s\n", prefix, source, name, value, typ)
+			default:
+				line = fmt.Sprintf("%s%s:%s|%s\n", prefix, name, value, typ)
+			}
+			// Keep datagrams below a typical MTU
+			if buf.Len()+len(line) > 1400 {
+				conn.Write(buf.Bytes())
+				buf.Reset()
+			}
+			buf.WriteString(line)
+		}
+		snap := c.metrics.snapshot()
+		for _, k := range sortedKeys(snap) {
+			delta := snap[k] - sent[k]
+			if delta == 0 {
+				continue
+			}
+			sent[k] = snap[k]
+			send(k.name, k.source, strconv.FormatInt(delta, 10), "c")
+		}
+		for _, st := range c.sourceStats() {
+			send("error_ratio", st.Source, statsdFloat(st.ErrorRate), "g")
+			if st.LastSuccess != nil {
+				send("last_success", st.Source, strconv.FormatInt(st.LastSuccess.Unix(), 10), "g")
+			}
+		}
+		for _, s := range sources {
+			if s.enabled {
+				send("poll_interval", s.Name, statsdFloat(s.pollInterval().Seconds()), "g")
+			}
+		}
+		leader := "0"
+		if c.isLeader() {
+			leader = "1"
+		}
+		send("leader", "", leader, "g")
+		if _, err := conn.Write(buf.Bytes()); err != nil {
+			c.logf(levelInfo, "statsd: %v", err)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestStatsdFloat(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{0, "0"},
+		{1, "1"},
+		{0.25, "0.25"},
+		{1e-05, "0.00001"},
+		{1.5e9, "1500000000"},
+		{60, "60"},
+	}
+	for _, tt := range tests {
+		if got := statsdFloat(tt.v); got != tt.want {
+			t.Errorf("%v: %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
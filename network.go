@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Network holds the settings governing how a source is fetched
+type Network struct {
+	Timeout      time.Duration
+	Proxy        string
+	RateLimit    time.Duration
+	Jitter       float64
+	Headers      map[string]string
+	Retries      int
+	RetryBackoff time.Duration
+	BindAddress  string
+}
+
+// network resolves the settings of s, or the global ones if s is nil. A
+// flag given, on the command line or in the config's options, wins over
+// the config, which wins over the flag's default. The more specific wins
+// within each: -<source>-bind-address over -bind-address, and the source's
+// config entry over the config's global network section.
+func (c *Crawler) network(s *Source) Network {
+	n := c.flags.network
+	n.Headers = make(map[string]string)
+	for k, v := range c.flags.network.Headers {
+		n.Headers[k] = v
+	}
+	c.config.Network.apply(&n)
+	if s == nil {
+		return c.flags.network.applyGiven(n)
+	}
+	if sc, ok := c.config.Sources[s.Name]; ok {
+		sc.NetworkConfig.apply(&n)
+	}
+	n = c.flags.network.applyGiven(n)
+	if s.bindAddress != "" {
+		n.BindAddress = s.bindAddress
+	}
+	return n
+}
+
+// applyGiven returns n with the settings whose flag was given taken from
+// the flags f
+func (f Network) applyGiven(n Network) Network {
+	if isSet("bind-address") {
+		n.BindAddress = f.BindAddress
+	}
+	if isSet("timeout") {
+		n.Timeout = f.Timeout
+	}
+	if isSet("proxy") {
+		n.Proxy = f.Proxy
+	}
+	if isSet("rate-limit") {
+		n.RateLimit = f.RateLimit
+	}
+	if isSet("jitter") {
+		n.Jitter = f.Jitter
+	}
+	if isSet("retries") {
+		n.Retries = f.Retries
+	}
+	if isSet("retry-backoff") {
+		n.RetryBackoff = f.RetryBackoff
+	}
+	return n
+}
+
+// jitter randomizes d by up to ±frac of itself, so that several instances
+// started together drift apart instead of polling in lockstep
+func jitter(d time.Duration, frac float64) time.Duration {
+	if d <= 0 || frac <= 0 {
+		return d
+	}
+	return d + time.Duration((rand.Float64()*2-1)*frac*float64(d))
+}
+
+// newClient returns an HTTP client configured by n. Connections originate
+// from n.BindAddress, an IPv4/IPv6 address or interface name, when set.
+func newClient(n Network) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if n.Proxy != "" {
+		u, err := url.Parse(n.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %q: %v", n.Proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	if n.BindAddress != "" {
+		ip, err := localIP(n.BindAddress)
+		if err != nil {
+			return nil, err
+		}
+		// Dialing out of an IPv6 address only works towards IPv6 peers and
+		// vice versa, so pin the address family to the local one.
+		network := "tcp4"
+		if ip.To4() == nil {
+			network = "tcp6"
+		}
+		dialer := &net.Dialer{LocalAddr: &net.TCPAddr{IP: ip}}
+		transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+	return &http.Client{Transport: transport, Timeout: n.Timeout}, nil
+}
+
+// localIP resolves bind to a local IP. Interface names resolve to their
+// first global unicast address, preferring IPv4.
+func localIP(bind string) (net.IP, error) {
+	if ip := net.ParseIP(bind); ip != nil {
+		return ip, nil
+	}
+	iface, err := net.InterfaceByName(bind)
+	if err != nil {
+		return nil, fmt.Errorf("bind address %q: %v", bind, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var found net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || !ipnet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			return ipnet.IP, nil
+		}
+		if found == nil {
+			found = ipnet.IP
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("interface %s has no usable address", bind)
+	}
+	return found, nil
+}
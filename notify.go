@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Notifier delivers messages meant for a human
+type Notifier interface {
+	Notify(subject, body string) error
+}
+
+// notify queues a message for every configured notifier. As notifiers are
+// only ever reached through their queue, it never waits on one.
+func (c *Crawler) notify(subject, body string) {
+	for _, n := range c.notifiers {
+		if err := n.Notify(subject, body); err != nil {
+			report(fmt.Errorf("notify: %v", err))
+		}
+	}
+}
+
+// notifyQueue caps the messages waiting for a notifier
+const notifyQueue = 100
+
+// notifyTimeout is how long a notifier may take over one message: -timeout,
+// or 30s when -timeout 0 turns request timeouts off
+func (c *Crawler) notifyTimeout() time.Duration {
+	if timeout := c.network(nil).Timeout; timeout > 0 {
+		return timeout
+	}
+	return 30 * time.Second
+}
+
+const metricNotifyDropped = "notifications_dropped"
+
+func init() {
+	metricHelp[metricNotifyDropped] = "Notifications dropped as their notifier's queue was full, by notifier"
+}
+
+// message is a notification waiting to be delivered
+type message struct {
+	subject string
+	body    string
+}
+
+// queuedNotifier delivers messages through n from a goroutine of its own,
+// so that a slow or unreachable notifier can't hold up the crawl. Messages
+// that find the queue full are dropped and counted.
+type queuedNotifier struct {
+	crawler *Crawler
+	kind    string
+	n       Notifier
+	queue   chan message
+	dropped int64
+}
+
+// queueNotifier starts delivering messages through n. Messages still queued
+// when the crawler stops are delivered within -shutdown-timeout.
+func (c *Crawler) queueNotifier(kind string, n Notifier) *queuedNotifier {
+	q := &queuedNotifier{crawler: c, kind: kind, n: n, queue: make(chan message, notifyQueue)}
+	deliver := func(m message) {
+		if err := q.n.Notify(m.subject, m.body); err != nil {
+			report(fmt.Errorf("notify: %s: %v", q.kind, err))
+		}
+	}
+	c.spawn(kind+" notifier", func() {
+		for {
+			select {
+			case m := <-q.queue:
+				deliver(m)
+			case <-c.ctx.Done():
+				for {
+					select {
+					case m := <-q.queue:
+						deliver(m)
+					default:
+						return
+					}
+				}
+			}
+		}
+	})
+	return q
+}
+
+// Notify queues the message without waiting
+func (q *queuedNotifier) Notify(subject, body string) error {
+	select {
+	case q.queue <- message{subject, body}:
+		return nil
+	default:
+	}
+	q.crawler.count(metricNotifyDropped, q.kind, 1)
+	n := atomic.AddInt64(&q.dropped, 1)
+	return fmt.Errorf("%s: %d messages waiting, dropped %q (%d dropped so far)", q.kind, notifyQueue, subject, n)
+}
+
+// smtpNotifier sends messages as emails
+type smtpNotifier struct {
+	addr     string
+	user     string
+	password string
+	from     string
+	to       []string
+	tls      string
+	insecure bool
+	timeout  time.Duration
+}
+
+// newSMTPNotifier builds the notifier configured by the -smtp* flags
+func (c *Crawler) newSMTPNotifier() (*smtpNotifier, error) {
+	n := &smtpNotifier{
+		addr:     c.flags.smtp,
+		user:     c.flags.smtpUser,
+		password: os.Getenv("MAILBOT_SMTP_PASSWORD"),
+		from:     c.flags.smtpFrom,
+		tls:      c.flags.smtpTLS,
+		insecure: c.flags.smtpInsecure,
+		timeout:  c.notifyTimeout(),
+	}
+	for _, to := range strings.Split(c.flags.smtpTo, ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			n.to = append(n.to, to)
+		}
+	}
+	switch {
+	case len(n.to) == 0:
+		return nil, errors.New("-smtp needs at least one -smtp-to recipient")
+	case n.from == "":
+		return nil, errors.New("-smtp needs -smtp-from")
+	case n.tls != "starttls" && n.tls != "tls" && n.tls != "none":
+		return nil, fmt.Errorf("-smtp-tls must be starttls, tls or none, not %q", n.tls)
+	}
+	if _, _, err := net.SplitHostPort(n.addr); err != nil {
+		return nil, fmt.Errorf("-smtp: %v", err)
+	}
+	return n, nil
+}
+
+func (n *smtpNotifier) Notify(subject, body string) error {
+	host, _, _ := net.SplitHostPort(n.addr)
+	config := &tls.Config{ServerName: host, InsecureSkipVerify: n.insecure}
+
+	// The whole exchange must end within -timeout, so that a stalled
+	// server can't keep the notifier waiting forever
+	dialer := &net.Dialer{Timeout: n.timeout}
+	conn, err := dialer.Dial("tcp", n.addr)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(n.timeout))
+	if n.tls == "tls" {
+		conn = tls.Client(conn, config)
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if n.tls == "starttls" {
+		if err := client.StartTLS(config); err != nil {
+			return err
+		}
+	}
+	if n.user != "" {
+		if err := client.Auth(smtp.PlainAuth("", n.user, n.password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(n.from); err != nil {
+		return err
+	}
+	for _, to := range n.to {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// digestLimit caps the addresses listed in one digest
+const digestLimit = 5000
+
+// digest collects the findings to be sent in the next digest
+type digest struct {
+	mu       sync.Mutex
+	findings []Finding
+	dropped  int
+}
+
+func (d *digest) add(f Finding) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.findings) >= digestLimit {
+		d.dropped++
+		return
+	}
+	d.findings = append(d.findings, f)
+}
+
+// prune drops the findings dated before cutoff
+func (d *digest) prune(cutoff time.Time) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	kept := d.findings[:0]
+	for _, f := range d.findings {
+		if !f.Time.Before(cutoff) {
+			kept = append(kept, f)
+		}
+	}
+	n := len(d.findings) - len(kept)
+	d.findings = kept
+	return n
+}
+
+// take empties the digest
+func (d *digest) take() ([]Finding, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	findings, dropped := d.findings, d.dropped
+	d.findings, d.dropped = nil, 0
+	return findings, dropped
+}
+
+// sendDigests emails a digest of new findings and crawler health every
+// interval, while this instance is the leader
+func (c *Crawler) sendDigests(n Notifier, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for c.tick(t) {
+		if !c.isLeader() {
+			continue
+		}
+		findings, dropped := c.takeDigest()
+		findings = c.unsuppressed(findings)
+		if err := n.Notify(c.digestMessage(interval, findings, dropped)); err != nil {
+			report(fmt.Errorf("digest: %v", err))
+		}
+	}
+}
+
+// digestMessage writes the subject and body of a digest
+func (c *Crawler) digestMessage(interval time.Duration, findings []Finding, dropped int) (string, string) {
+	total := len(findings) + dropped
+	subject := fmt.Sprintf("mailbot digest: %d new addresses in the last %s", total, interval)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d new unique addresses in the last %s.\n\n", total, interval)
+	b.WriteString("Source health\n")
+	for _, st := range c.sourceStats() {
+		state := "ok"
+		switch {
+		case !st.Enabled:
+			state = "disabled"
+		case st.ErrorRate > 0.5:
+			state = "failing"
+		}
+		last := "never"
+		if st.LastSuccess != nil {
+			last = st.LastSuccess.Format(time.RFC3339)
+		}
+		fmt.Fprintf(&b, "  %-10s %-8s %6d pastes  %6d emails  %5.1f%% errors  last success %s\n",
+			st.Source, state, st.Fetched, st.Emails, st.ErrorRate*100, last)
+	}
+	if len(findings) > 0 {
+		b.WriteString("\nNew addresses\n")
+	}
+	for _, f := range findings {
+		fmt.Fprintf(&b, "  %s  %s  %s\n", f.Email, f.Source, f.URL)
+	}
+	if dropped > 0 {
+		fmt.Fprintf(&b, "  ... and %d more\n", dropped)
+	}
+	return subject, b.String()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Fetched pages go through two bounded queues: to the extract workers,
+// then to the single sink writing the output. A full queue blocks the stage
+// feeding it, so a stalled sink holds up extraction, which holds up the
+// fetch slots of every source, instead of pages piling up in memory.
+
+// fetchedPage is a fetched paste waiting for extraction
+type fetchedPage struct {
+	source  *Source
+	url     string
+	body    []byte
+	fetched time.Time
+}
+
+// batch is the addresses extracted from one paste, waiting for the sink
+type batch struct {
+	source  *Source
+	url     string
+	sum     string
+	mails   []string
+	fetched time.Time
+}
+
+// pipeline holds the queues between the stages
+type pipeline struct {
+	pages   chan fetchedPage
+	batches chan batch
+
+	mu      sync.Mutex
+	idle    *sync.Cond
+	pending int
+	// limit, when set, caps pending below the queue sizes
+	limit int
+	room  *sync.Cond
+}
+
+// startPipeline starts the extract workers and the sink
+func (c *Crawler) startPipeline() {
+	p := &c.pipeline
+	p.pages = make(chan fetchedPage, c.flags.extractQueue)
+	p.batches = make(chan batch, c.flags.sinkQueue)
+	p.idle = sync.NewCond(&p.mu)
+	p.room = sync.NewCond(&p.mu)
+	for i := 0; i < c.flags.extractWorkers; i++ {
+		c.spawn("extract", c.extractLoop)
+	}
+	c.spawn("sink", c.sinkLoop)
+}
+
+// add counts a new page or batch in, waiting while the limit is reached
+func (p *pipeline) add() {
+	p.mu.Lock()
+	for p.limit > 0 && p.pending >= p.limit {
+		p.room.Wait()
+	}
+	p.pending++
+	p.mu.Unlock()
+}
+
+func (p *pipeline) done() {
+	p.mu.Lock()
+	p.pending--
+	if p.pending == 0 {
+		p.idle.Broadcast()
+	}
+	p.room.Signal()
+	p.mu.Unlock()
+}
+
+// setLimit caps the pages and batches in the pipeline at n, or lifts the
+// cap if n is 0
+func (p *pipeline) setLimit(n int) {
+	p.mu.Lock()
+	p.limit = n
+	p.room.Broadcast()
+	p.mu.Unlock()
+}
+
+// drain waits until everything queued so far went through the sink
+func (c *Crawler) drain() {
+	p := &c.pipeline
+	if p.idle == nil {
+		return
+	}
+	p.mu.Lock()
+	for p.pending > 0 {
+		p.idle.Wait()
+	}
+	p.mu.Unlock()
+}
+
+// idle reports whether nothing is queued
+func (p *pipeline) isIdle() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pending == 0
+}
+
+// stage runs fn on what arrives on ch. Once the crawler stops it goes on
+// until the pipeline is empty, so what was fetched is still written.
+func stage[T any](c *Crawler, ch <-chan T, fn func(T)) {
+	for {
+		select {
+		case v := <-ch:
+			fn(v)
+			continue
+		case <-c.ctx.Done():
+		}
+		if c.pipeline.isIdle() {
+			return
+		}
+		select {
+		case v := <-ch:
+			fn(v)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (c *Crawler) extractLoop() {
+	stage(c, c.pipeline.pages, func(pg fetchedPage) {
+		c.scanKeywords(pg.source, pg.url, pg.body)
+		mails := extract(pg.url, pg.body)
+		if mails == nil {
+			c.pipeline.done()
+			return
+		}
+		var sum string
+		if c.audit.out != nil {
+			sum = hashPage(pg.body)
+		}
+		c.pipeline.batches <- batch{pg.source, pg.url, sum, mails, pg.fetched}
+	})
+}
+
+func (c *Crawler) sinkLoop() {
+	stage(c, c.pipeline.batches, func(b batch) {
+		c.write(b)
+		c.pipeline.done()
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// How an adaptive poll interval changes after a crawl: it is stretched when
+// the listing held nothing new and tightened when more than busyShare of it
+// was new, since pastes posted between two polls may then have been missed.
+const (
+	pollStretch = 1.5
+	pollTighten = 0.5
+	busyShare   = 0.5
+)
+
+// setupPolling sets the poll interval bounds of every source from the
+// flags and the config
+func (c *Crawler) setupPolling() error {
+	for _, s := range sources {
+		s.minInterval, s.maxInterval = c.flags.minInterval, c.flags.maxInterval
+		if sc, ok := c.config.Sources[s.Name]; ok {
+			if sc.MinInterval != nil {
+				s.minInterval = time.Duration(*sc.MinInterval)
+			}
+			if sc.MaxInterval != nil {
+				s.maxInterval = time.Duration(*sc.MaxInterval)
+			}
+		}
+		if s.minInterval == 0 {
+			s.minInterval = c.flags.interval
+		}
+		if s.maxInterval == 0 {
+			s.maxInterval = c.flags.interval
+		}
+		if s.minInterval > s.maxInterval {
+			return fmt.Errorf("%s: min interval %s is above max interval %s", s.Name, s.minInterval, s.maxInterval)
+		}
+		s.interval = clampDuration(c.flags.interval, s.minInterval, s.maxInterval)
+	}
+	return nil
+}
+
+// adaptivePolling reports whether any source has its own poll interval
+func (c *Crawler) adaptivePolling() bool {
+	for _, s := range sources {
+		if s.enabled && s.minInterval < s.maxInterval {
+			return true
+		}
+	}
+	return false
+}
+
+// due reports whether s should be crawled at now
+func (s *Source) due(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.nextPoll.After(now)
+}
+
+// pollInterval returns the current poll interval of s
+func (s *Source) pollInterval() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.interval
+}
+
+// adapt stretches or tightens the poll interval of s after a crawl that
+// listed pastes of which fresh weren't seen before, and sets when s is next
+// due
+func (c *Crawler) adapt(s *Source, listed, fresh int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.interval
+	// A failed listing says nothing about the source's activity
+	if atomic.LoadInt32(&s.failures) == 0 {
+		switch {
+		case fresh == 0:
+			s.interval = time.Duration(float64(s.interval) * pollStretch)
+		case float64(fresh) > busyShare*float64(listed):
+			s.interval = time.Duration(float64(s.interval) * pollTighten)
+		}
+		s.interval = clampDuration(s.interval, s.minInterval, s.maxInterval)
+	}
+	if s.interval != old {
+		c.logf(levelInfo, "%s: %d/%d pastes new, polling every %s", s.Name, fresh, listed, s.interval.Round(time.Millisecond))
+	}
+	s.nextPoll = time.Now().Add(jitter(s.interval, s.network.Jitter))
+}
+
+// untilDue returns how long until the first source that would be crawled
+// is due
+func (c *Crawler) untilDue(now time.Time) time.Duration {
+	wait, found := c.flags.interval, false
+	for _, s := range sources {
+		if !s.enabled || s.isPaused() || atomic.LoadInt32(&c.paused) != 0 {
+			continue
+		}
+		s.mu.Lock()
+		if d := s.nextPoll.Sub(now); !found || d < wait {
+			wait, found = d, true
+		}
+		s.mu.Unlock()
+	}
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// clampDuration returns d bounded to [min, max]
+func clampDuration(d, min, max time.Duration) time.Duration {
+	if d < min {
+		return min
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
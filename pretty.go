@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ANSI colors given to the sources in turn
+var palette = []string{"36", "33", "35", "32", "34", "31"}
+
+const (
+	ansiReset = "\x1b[0m"
+	ansiClear = "\r\x1b[K"
+)
+
+var spinner = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// isTerminal reports whether f is connected to a terminal that takes
+// colors, that is unless TERM is dumb or NO_COLOR is set
+func isTerminal(f *os.File) bool {
+	if os.Getenv("TERM") == "dumb" || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return isTTY(f)
+}
+
+// color wraps text in the source's color
+func (s *Source) color(text string) string {
+	for i, other := range sources {
+		if other == s {
+			return "\x1b[" + palette[i%len(palette)] + "m" + text + ansiReset
+		}
+	}
+	return text
+}
+
+// printFindings prints new addresses to stdout, tagged with their source in
+// pretty mode. The caller holds c.mu.
+func (c *Crawler) printFindings(s *Source, mails []string) {
+	if !c.flags.pretty {
+		fmt.Println(strings.Join(mails, "\n"))
+		return
+	}
+	var b strings.Builder
+	b.WriteString(ansiClear)
+	for _, mail := range mails {
+		fmt.Fprintf(&b, "%s %s\n", s.color("["+s.Name+"]"), mail)
+	}
+	os.Stdout.WriteString(b.String())
+}
+
+// progress shows a spinner with the text returned by status on stdout
+// until the returned function is called. It does nothing outside pretty
+// mode.
+func (c *Crawler) progress(status func() string) func() {
+	if !c.flags.pretty {
+		return func() {}
+	}
+	var stopped int32
+	done := make(chan struct{})
+	c.spawn("progress", func() {
+		defer close(done)
+		for i := 0; atomic.LoadInt32(&stopped) == 0; i++ {
+			c.mu.Lock()
+			fmt.Printf("%s\x1b[2m%s %s%s", ansiClear, spinner[i%len(spinner)], status(), ansiReset)
+			c.mu.Unlock()
+			time.Sleep(100 * time.Millisecond)
+		}
+		c.mu.Lock()
+		fmt.Print(ansiClear)
+		c.mu.Unlock()
+	})
+	return func() {
+		atomic.StoreInt32(&stopped, 1)
+		<-done
+	}
+}
+
+// cycleProgress describes the progress of the running cycle
+func (c *Crawler) cycleProgress(cycle int, start map[metricKey]int64) func() string {
+	return func() string {
+		listed, fetched, skipped := c.cycleCounts(start)
+		return fmt.Sprintf("cycle %d: %d/%d pastes fetched", cycle, fetched, listed-skipped)
+	}
+}
+
+// cycleCounts returns how many pastes were listed, fetched and skipped
+// since start, over all sources
+func (c *Crawler) cycleCounts(start map[metricKey]int64) (listed, fetched, skipped int64) {
+	snap := c.metrics.snapshot()
+	for _, s := range sources {
+		listed += snap[metricKey{metricListed, s.Name}] - start[metricKey{metricListed, s.Name}]
+		fetched += snap[metricKey{metricPastes, s.Name}] - start[metricKey{metricPastes, s.Name}]
+		skipped += snap[metricKey{metricSkipped, s.Name}] - start[metricKey{metricSkipped, s.Name}]
+	}
+	return listed, fetched, skipped
+}
+
+// printCycle prints the per-source counts of a finished cycle in pretty
+// mode
+func (c *Crawler) printCycle(cycle int, start map[metricKey]int64, took time.Duration) {
+	if !c.flags.pretty {
+		return
+	}
+	snap := c.metrics.snapshot()
+	var parts []string
+	for _, s := range sources {
+		pastes := snap[metricKey{metricPastes, s.Name}] - start[metricKey{metricPastes, s.Name}]
+		emails := snap[metricKey{metricEmails, s.Name}] - start[metricKey{metricEmails, s.Name}]
+		errs := snap[metricKey{metricRequestErrors, s.Name}] - start[metricKey{metricRequestErrors, s.Name}]
+		if !s.enabled {
+			continue
+		}
+		part := fmt.Sprintf("%s %d pastes, %d new", s.color(s.Name), pastes, emails)
+		if errs > 0 {
+			part += fmt.Sprintf(", \x1b[31m%d errors%s", errs, ansiReset)
+		}
+		parts = append(parts, part)
+	}
+	c.mu.Lock()
+	fmt.Printf("%s\x1b[1mcycle %d\x1b[0m done in %s: %s\n", ansiClear, cycle, took.Round(time.Millisecond), strings.Join(parts, " · "))
+	c.mu.Unlock()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Just enough of the protobuf wire format for the messages in mailbot.proto
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+func appendTag(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wire))
+}
+
+func appendString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendMessage(b []byte, field int, m []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(m)))
+	return append(b, m...)
+}
+
+func appendInt64(b []byte, field int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendTag(b, field, wireVarint)
+	return binary.AppendUvarint(b, uint64(v))
+}
+
+func appendBool(b []byte, field int, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = appendTag(b, field, wireVarint)
+	return append(b, 1)
+}
+
+func appendDouble(b []byte, field int, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendTag(b, field, wireFixed64)
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+}
+
+// protoFields are the decoded fields of a message, by field number. For
+// repeated fields only the last value is kept, except in all.
+type protoFields struct {
+	ints  map[int]uint64
+	bytes map[int][]byte
+	all   map[int][][]byte
+}
+
+var errProto = errors.New("malformed protobuf message")
+
+func decodeProto(b []byte) (protoFields, error) {
+	f := protoFields{
+		ints:  make(map[int]uint64),
+		bytes: make(map[int][]byte),
+		all:   make(map[int][][]byte),
+	}
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return f, errProto
+		}
+		b = b[n:]
+		field, wire := int(tag>>3), int(tag&7)
+		switch wire {
+		case wireVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return f, errProto
+			}
+			f.ints[field] = v
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return f, errProto
+			}
+			f.ints[field] = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return f, errProto
+			}
+			f.ints[field] = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return f, errProto
+			}
+			v := b[n : n+int(l)]
+			f.bytes[field] = v
+			f.all[field] = append(f.all[field], v)
+			b = b[n+int(l):]
+		default:
+			return f, errProto
+		}
+	}
+	return f, nil
+}
+
+func (f protoFields) string(field int) string {
+	return string(f.bytes[field])
+}
+
+func (f protoFields) int64(field int) int64 {
+	return int64(f.ints[field])
+}
+
+func (f protoFields) bool(field int) bool {
+	return f.ints[field] != 0
+}
+
+func (f protoFields) double(field int) float64 {
+	return math.Float64frombits(f.ints[field])
+}
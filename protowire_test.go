@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProtoEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		got  []byte
+		want []byte
+	}{
+		// The examples of the protobuf encoding guide
+		{"varint", appendInt64(nil, 1, 150), []byte{0x08, 0x96, 0x01}},
+		{"string", appendString(nil, 2, "testing"), []byte("\x12\x07testing")},
+		{"message", appendMessage(nil, 3, []byte{0x08, 0x96, 0x01}), []byte{0x1a, 0x03, 0x08, 0x96, 0x01}},
+		{"bool", appendBool(nil, 4, true), []byte{0x20, 0x01}},
+		{"double", appendDouble(nil, 5, 1), []byte{0x29, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f}},
+		{"negative", appendInt64(nil, 1, -1), []byte{0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		// Zero values are left out
+		{"zeros", appendDouble(appendBool(appendString(appendInt64(nil, 1, 0), 2, ""), 3, false), 4, 0), nil},
+	}
+	for _, tt := range tests {
+		if !bytes.Equal(tt.got, tt.want) {
+			t.Errorf("%s: % x, want % x", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestProtoDecode(t *testing.T) {
+	var m []byte
+	m = appendString(m, 1, "id")
+	m = appendString(m, 2, "a@x.com")
+	m = appendString(m, 2, "b@x.com")
+	m = appendInt64(m, 3, -42)
+	m = appendBool(m, 4, true)
+	m = appendDouble(m, 5, 0.25)
+	m = append(m, 0x35, 1, 0, 0, 0) // fixed32 field 6
+	f, err := decodeProto(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.string(1) != "id" || f.string(2) != "b@x.com" || f.int64(3) != -42 || !f.bool(4) || f.double(5) != 0.25 || f.int64(6) != 1 {
+		t.Errorf("decoded %+v", f)
+	}
+	if got := f.all[2]; !reflect.DeepEqual(got, [][]byte{[]byte("a@x.com"), []byte("b@x.com")}) {
+		t.Errorf("repeated field %q", got)
+	}
+	if f.string(7) != "" || f.int64(7) != 0 || f.bool(7) {
+		t.Errorf("missing field isn't zero")
+	}
+
+	for _, bad := range [][]byte{
+		{0x08},             // varint cut off
+		{0x08, 0x96},       // varint unterminated
+		{0x12, 0x07, 't'},  // string shorter than its length
+		{0x29, 0, 0, 0},    // fixed64 cut off
+		{0x35, 0},          // fixed32 cut off
+		{0x0b},             // group, unsupported
+		{0x80},             // tag unterminated
+		{0x12, 0xff, 0xff}, // length unterminated
+	} {
+		if _, err := decodeProto(bad); err != errProto {
+			t.Errorf("% x: error %v, want %v", bad, err, errProto)
+		}
+	}
+}
+
+func TestEncodeFinding(t *testing.T) {
+	now := time.Unix(1700000000, 5)
+	tests := []Finding{
+		{Email: "a@x.com", Source: "pastebin", URL: "https://pastebin.com/raw/abc", Time: now, Campaign: "acme"},
+		{Email: "b@x.com", Source: "slexy", URL: "http://slexy.org/raw/1", Time: now},
+	}
+	for _, want := range tests {
+		f, err := decodeProto(encodeFinding(want))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := Finding{Email: f.string(1), Source: f.string(2), URL: f.string(3), Time: time.Unix(0, f.int64(4)), Campaign: f.string(5)}
+		if !got.Time.Equal(want.Time) {
+			t.Errorf("time %v, want %v", got.Time, want.Time)
+		}
+		got.Time = want.Time
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("decoded %+v, want %+v", got, want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Finding is an address along with where and when it was found: the
+// source, the paste, and the time the paste was fetched
+type Finding struct {
+	Email    string    `json:"email"`
+	Source   string    `json:"source"`
+	URL      string    `json:"url"`
+	Time     time.Time `json:"time"`
+	Campaign string    `json:"campaign,omitempty"`
+}
+
+// MarshalJSON writes an unknown, zero, time as null
+func (f Finding) MarshalJSON() ([]byte, error) {
+	type plain Finding
+	if f.Time.IsZero() {
+		return json.Marshal(struct {
+			plain
+			Time *time.Time `json:"time"`
+		}{plain: plain(f)})
+	}
+	return json.Marshal(plain(f))
+}
+
+const recentSize = 100
+
+// recentFindings keeps the last recentSize findings
+type recentFindings struct {
+	mu    sync.Mutex
+	items [recentSize]Finding
+	n     int
+}
+
+// add records f, dropping the oldest finding when full
+func (r *recentFindings) add(f Finding) {
+	r.mu.Lock()
+	r.items[r.n%recentSize] = f
+	r.n++
+	r.mu.Unlock()
+}
+
+// remove drops the findings drop matches
+func (r *recentFindings) remove(drop func(Finding) bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var kept []Finding
+	for i := r.n - recentSize; i < r.n; i++ {
+		if i >= 0 && !drop(r.items[i%recentSize]) {
+			kept = append(kept, r.items[i%recentSize])
+		}
+	}
+	r.items, r.n = [recentSize]Finding{}, 0
+	for _, f := range kept {
+		r.items[r.n] = f
+		r.n++
+	}
+}
+
+// list returns up to max findings, newest first
+func (r *recentFindings) list(max int) []Finding {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []Finding
+	for i := r.n - 1; i >= 0 && i >= r.n-recentSize && len(out) < max; i-- {
+		out = append(out, r.items[i%recentSize])
+	}
+	return out
+}
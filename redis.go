@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The little of the Redis protocol (RESP) mailbot needs
+
+// redisTimeout bounds dialing the server and each command, on top of how
+// long a blocking command is asked to wait
+const redisTimeout = 10 * time.Second
+
+// redisError is an error reply of the server
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisConn is one connection to the server
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// redisClient sends commands over a pool of connections, so that blocking
+// commands don't hold up the others
+type redisClient struct {
+	addr     string
+	password string
+	db       int
+	idle     chan *redisConn
+}
+
+// newRedisClient parses a redis://[:password@]host[:port][/db] URL
+func newRedisClient(rawurl string) (*redisClient, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("redis url: %v", err)
+	}
+	if u.Scheme != "redis" || u.Host == "" {
+		return nil, fmt.Errorf("redis url: want redis://host:port/db")
+	}
+	r := &redisClient{addr: u.Host, idle: make(chan *redisConn, 16)}
+	if u.Port() == "" {
+		r.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		r.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if r.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("redis url: bad database %q", db)
+		}
+	}
+	return r, nil
+}
+
+// dial opens and prepares a new connection
+func (r *redisClient) dial() (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", r.addr, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{conn, bufio.NewReader(conn)}
+	if r.password != "" {
+		if _, err := rc.do("AUTH", r.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if r.db != 0 {
+		if _, err := rc.do("SELECT", strconv.Itoa(r.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// do sends a command and returns its reply: a string, an int64, nil or a
+// []interface{} of those
+func (r *redisClient) do(args ...string) (interface{}, error) {
+	var rc *redisConn
+	select {
+	case rc = <-r.idle:
+	default:
+		var err error
+		if rc, err = r.dial(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := rc.do(args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		// The connection may be out of step, don't reuse it
+		rc.conn.Close()
+		return nil, err
+	}
+	select {
+	case r.idle <- rc:
+	default:
+		rc.conn.Close()
+	}
+	return reply, err
+}
+
+// int calls do and expects an integer reply
+func (r *redisClient) int(args ...string) (int64, error) {
+	reply, err := r.do(args...)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: %s: unexpected reply %v", args[0], reply)
+	}
+	return n, nil
+}
+
+func (rc *redisConn) do(args ...string) (interface{}, error) {
+	timeout := redisTimeout
+	if strings.EqualFold(args[0], "BRPOP") {
+		block, _ := strconv.Atoi(args[len(args)-1])
+		timeout += time.Duration(block) * time.Second
+	}
+	if err := rc.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := rc.conn.Write([]byte(b.String())); err != nil {
+		return nil, err
+	}
+	return rc.read()
+}
+
+// read parses one reply
+func (rc *redisConn) read() (interface{}, error) {
+	line, err := rc.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = rc.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
+
+// redisStore is a Store shared by every instance using the same server,
+// keeping each kind in a set
+type redisStore struct {
+	client *redisClient
+	prefix string
+}
+
+func (s *redisStore) Add(kind, key string) (bool, error) {
+	n, err := s.client.int("SADD", s.prefix+kind, key)
+	return n == 1, err
+}
+
+func (s *redisStore) Remove(kind, key string) error {
+	_, err := s.client.do("SREM", s.prefix+kind, key)
+	return err
+}
+
+func (s *redisStore) Search(kind, substr string, limit int) ([]string, error) {
+	var found []string
+	pattern := "*" + globFold(substr) + "*"
+	cursor := "0"
+	for {
+		reply, err := s.client.do("SSCAN", s.prefix+kind, cursor, "MATCH", pattern, "COUNT", "1000")
+		if err != nil {
+			return nil, err
+		}
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 2 {
+			return nil, errors.New("redis: unexpected SSCAN reply")
+		}
+		cursor, _ = parts[0].(string)
+		keys, _ := parts[1].([]interface{})
+		for _, k := range keys {
+			if k, ok := k.(string); ok {
+				found = append(found, k)
+			}
+		}
+		if cursor == "0" || cursor == "" {
+			break
+		}
+	}
+	sort.Strings(found)
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	return found, nil
+}
+
+// globFold returns a Redis glob pattern matching s literally, ignoring case
+func globFold(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		lower, upper := unicode.ToLower(r), unicode.ToUpper(r)
+		switch {
+		case lower != upper:
+			fmt.Fprintf(&b, "[%c%c]", lower, upper)
+		case strings.ContainsRune(`*?[]\^`, r):
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
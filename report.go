@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Report is what mailbot stats makes of collected output
+type Report struct {
+	Records    int             `json:"records"`
+	Unique     int             `json:"unique"`
+	Sources    []SourceYield   `json:"sources,omitempty"`
+	Domains    []Count         `json:"top_domains"`
+	TLDs       []Count         `json:"top_tlds"`
+	Discovered []DiscoveryRate `json:"discovered,omitempty"`
+}
+
+// SourceYield is how many new addresses a source gave, and from how many
+// pastes
+type SourceYield struct {
+	Source   string  `json:"source"`
+	Unique   int     `json:"unique"`
+	Pastes   int     `json:"pastes"`
+	PerPaste float64 `json:"per_paste"`
+}
+
+// Count is how often a key came up
+type Count struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// DiscoveryRate is how many addresses were first seen in a time bucket
+type DiscoveryRate struct {
+	Start time.Time `json:"start"`
+	New   int       `json:"new"`
+}
+
+// statsRecord is a line of any file mailbot writes findings to: plain
+// addresses, or JSON with at least an email such as the findings of the
+// spool and the sinks and the "finding" records of the audit log
+type statsRecord struct {
+	Type     string    `json:"type"`
+	Email    string    `json:"email"`
+	Source   string    `json:"source"`
+	URL      string    `json:"url"`
+	Time     time.Time `json:"time"`
+	Campaign string    `json:"campaign"`
+}
+
+// readStats adds the findings in the file at path to the report being
+// built. Addresses already seen count once, from their first record. With
+// a campaign, only the findings tagged with it are read.
+func readStats(path, campaign string, seen map[string]*statsRecord, order *[]*statsRecord) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	records := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var r statsRecord
+		if strings.HasPrefix(line, "{") {
+			if json.Unmarshal([]byte(line), &r) != nil || r.Email == "" || r.Type != "" && r.Type != "finding" {
+				continue
+			}
+		} else if strings.Contains(line, "@") && !strings.HasPrefix(line, "#") {
+			f := parsePlain(line)
+			r = statsRecord{Email: f.Email, Source: f.Source, URL: f.URL, Time: f.Time, Campaign: f.Campaign}
+		} else {
+			continue
+		}
+		if campaign != "" && r.Campaign != campaign {
+			continue
+		}
+		records++
+		key := strings.ToLower(r.Email)
+		if first, ok := seen[key]; ok {
+			// A later file may know more of it
+			if first.Source == "" {
+				first.Source, first.URL = r.Source, r.URL
+			}
+			if first.Time.IsZero() || !r.Time.IsZero() && r.Time.Before(first.Time) {
+				first.Time = r.Time
+			}
+			continue
+		}
+		seen[key] = &r
+		*order = append(*order, &r)
+	}
+	return records, scanner.Err()
+}
+
+// topCounts returns the n most frequent keys of counts, most frequent first
+func topCounts(counts map[string]int, n int) []Count {
+	top := make([]Count, 0, len(counts))
+	for k, v := range counts {
+		top = append(top, Count{k, v})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Key < top[j].Key
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// buildReport summarizes the unique findings in the order first seen
+func buildReport(records int, found []*statsRecord, top int, bucket time.Duration) Report {
+	rep := Report{Records: records, Unique: len(found)}
+	domains, tlds := make(map[string]int), make(map[string]int)
+	yields := make(map[string]*SourceYield)
+	pastes := make(map[string]map[string]bool)
+	buckets := make(map[time.Time]int)
+	for _, r := range found {
+		domain := strings.ToLower(r.Email[strings.LastIndex(r.Email, "@")+1:])
+		domains[domain]++
+		tlds[domain[strings.LastIndex(domain, ".")+1:]]++
+		if r.Source != "" {
+			y := yields[r.Source]
+			if y == nil {
+				y = &SourceYield{Source: r.Source}
+				yields[r.Source] = y
+				pastes[r.Source] = make(map[string]bool)
+			}
+			y.Unique++
+			if r.URL != "" {
+				pastes[r.Source][r.URL] = true
+			}
+		}
+		if !r.Time.IsZero() {
+			buckets[r.Time.Truncate(bucket)]++
+		}
+	}
+	rep.Domains, rep.TLDs = topCounts(domains, top), topCounts(tlds, top)
+	for name, y := range yields {
+		y.Pastes = len(pastes[name])
+		if y.Pastes > 0 {
+			y.PerPaste = float64(y.Unique) / float64(y.Pastes)
+		}
+		rep.Sources = append(rep.Sources, *y)
+	}
+	sort.Slice(rep.Sources, func(i, j int) bool { return rep.Sources[i].Unique > rep.Sources[j].Unique })
+	for start, n := range buckets {
+		rep.Discovered = append(rep.Discovered, DiscoveryRate{start, n})
+	}
+	sort.Slice(rep.Discovered, func(i, j int) bool { return rep.Discovered[i].Start.Before(rep.Discovered[j].Start) })
+	return rep
+}
+
+// Stats prints statistics over output, audit, spool or sink files. Plain
+// address files count towards the totals and domains only, as they don't
+// say where or when an address was found.
+func (c *Crawler) Stats(paths []string) {
+	if len(paths) == 0 {
+		reportFatal(errors.New("usage: mailbot stats [flags] file..."))
+		os.Exit(exitConfig)
+	}
+	if c.flags.statsTop < 1 || c.flags.statsBucket <= 0 {
+		reportFatal(errors.New("-top and -bucket must be positive"))
+		os.Exit(exitConfig)
+	}
+	var (
+		seen    = make(map[string]*statsRecord)
+		found   []*statsRecord
+		records int
+	)
+	for _, path := range paths {
+		n, err := readStats(path, c.flags.campaign, seen, &found)
+		if err != nil {
+			reportFatal(err)
+			os.Exit(exitConfig)
+		}
+		records += n
+	}
+	r := buildReport(records, found, c.flags.statsTop, c.flags.statsBucket)
+	if c.flags.json {
+		b, _ := json.MarshalIndent(r, "", "  ")
+		fmt.Println(string(b))
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "records\t%d\n", r.Records)
+	fmt.Fprintf(w, "unique addresses\t%d\n", r.Unique)
+	if len(r.Sources) > 0 {
+		fmt.Fprint(w, "\nsource\tunique\tpastes\tper paste\n")
+		for _, y := range r.Sources {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%.2f\n", y.Source, y.Unique, y.Pastes, y.PerPaste)
+		}
+	}
+	fmt.Fprint(w, "\ntop domains\t\n")
+	for _, d := range r.Domains {
+		fmt.Fprintf(w, "%s\t%d\n", d.Key, d.Count)
+	}
+	fmt.Fprint(w, "\ntop TLDs\t\n")
+	for _, t := range r.TLDs {
+		fmt.Fprintf(w, "%s\t%d\n", t.Key, t.Count)
+	}
+	if len(r.Discovered) > 0 {
+		layout := "2006-01-02 15:04"
+		if c.flags.statsBucket < time.Minute {
+			layout += ":05"
+		}
+		fmt.Fprintf(w, "\nnew per %s\t\n", c.flags.statsBucket)
+		for _, d := range r.Discovered {
+			fmt.Fprintf(w, "%s\t%d\n", d.Start.Local().Format(layout), d.New)
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// memoryPressure is the share of -max-memory above which queues shrink and
+// caches are evicted
+const memoryPressure = 0.9
+
+// minGoroutines is the lowest -max-goroutines, below what mailbot needs
+// for its servers and loops alone
+const minGoroutines = 32
+
+// parseSize parses a byte count with an optional K, M or G suffix, in
+// powers of 1024, e.g. 512M or 2GiB
+func parseSize(s string) (int64, error) {
+	n := strings.ToUpper(strings.TrimSpace(s))
+	n = strings.TrimSuffix(strings.TrimSuffix(n, "B"), "I")
+	shift := 0
+	switch {
+	case strings.HasSuffix(n, "K"):
+		shift = 10
+	case strings.HasSuffix(n, "M"):
+		shift = 20
+	case strings.HasSuffix(n, "G"):
+		shift = 30
+	}
+	if shift > 0 {
+		n = n[:len(n)-1]
+	}
+	v, err := strconv.ParseInt(n, 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	return v << uint(shift), nil
+}
+
+// limitMemory sets the soft memory limit of the runtime and watches the
+// memory in use, shrinking the queues and evicting caches when it nears
+// the limit
+func (c *Crawler) limitMemory(limit int64) {
+	debug.SetMemoryLimit(limit)
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	pressed := false
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for c.tick(t) {
+		metrics.Read(samples)
+		used := int64(samples[0].Value.Uint64() - samples[1].Value.Uint64())
+		high := float64(used) > memoryPressure*float64(limit)
+		if high == pressed {
+			continue
+		}
+		pressed = high
+		if !high {
+			c.logf(levelInfo, "memory: %d MiB in use, restoring queues", used>>20)
+			c.pipeline.setLimit(0)
+			continue
+		}
+		c.logf(levelInfo, "memory: %d of %d MiB in use, shrinking queues and evicting caches", used>>20, limit>>20)
+		c.pipeline.setLimit(c.flags.extractWorkers)
+		if m, ok := c.store.(*memoryStore); ok {
+			// Pastes seen are only fetched again, addresses seen would
+			// be written again
+			m.evict(kindPaste)
+		}
+		runtime.GC()
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errRobots is returned for the URLs robots.txt doesn't let mailbot fetch
+var errRobots = errors.New("disallowed by robots.txt")
+
+const (
+	// robotsTTL is how long a robots.txt is trusted
+	robotsTTL = 24 * time.Hour
+	// robotsRetry is how long a site whose robots.txt couldn't be read is
+	// left alone
+	robotsRetry = time.Minute
+)
+
+// robotsRule is an Allow or Disallow line. length, that of its pattern,
+// tells which of the rules matching a path wins.
+type robotsRule struct {
+	allow   bool
+	pattern *regexp.Regexp
+	length  int
+}
+
+// newRobotsRule compiles a path pattern, where * matches anything and a
+// final $ anchors the end
+func newRobotsRule(allow bool, pattern string) robotsRule {
+	expr := strings.Replace(regexp.QuoteMeta(pattern), `\*`, ".*", -1)
+	if strings.HasSuffix(expr, `\$`) {
+		expr = strings.TrimSuffix(expr, `\$`) + "$"
+	}
+	return robotsRule{allow, regexp.MustCompile("^" + expr), len(pattern)}
+}
+
+// robotsRules is what a site's robots.txt asks of mailbot
+type robotsRules struct {
+	rules   []robotsRule
+	delay   time.Duration
+	expires time.Time
+}
+
+// allowed reports whether path may be fetched. The longest matching rule
+// wins, Allow over Disallow on a tie, as RFC 9309 has it.
+func (r *robotsRules) allowed(path string) bool {
+	best, allow := -1, true
+	for _, rule := range r.rules {
+		if rule.length < best || !rule.pattern.MatchString(path) {
+			continue
+		}
+		if rule.length > best || rule.allow {
+			best, allow = rule.length, rule.allow
+		}
+	}
+	return allow
+}
+
+// parseRobots returns the rules of the groups of a robots.txt naming agent,
+// or of those for every robot if none does
+func parseRobots(body []byte, agent string) *robotsRules {
+	var (
+		mine, everyone robotsRules
+		named          bool
+		inRules        bool // a user-agent line after a rule starts a group
+		forMe, forAll  bool
+	)
+	for _, line := range strings.Split(string(body), "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:colon]))
+		value := strings.TrimSpace(line[colon+1:])
+		switch key {
+		case "user-agent":
+			if inRules {
+				forMe, forAll, inRules = false, false, false
+			}
+			switch ua := strings.ToLower(value); {
+			case ua == "*":
+				forAll = true
+			case ua == agent:
+				forMe, named = true, true
+			}
+		case "allow", "disallow", "crawl-delay":
+			inRules = true
+			var groups []*robotsRules
+			if forMe {
+				groups = append(groups, &mine)
+			}
+			if forAll {
+				groups = append(groups, &everyone)
+			}
+			for _, g := range groups {
+				if key == "crawl-delay" {
+					if secs, err := strconv.ParseFloat(value, 64); err == nil && secs > 0 {
+						g.delay = time.Duration(secs * float64(time.Second))
+					}
+				} else if value != "" {
+					// An empty Disallow allows everything
+					g.rules = append(g.rules, newRobotsRule(key == "allow", value))
+				}
+			}
+		}
+	}
+	if named {
+		return &mine
+	}
+	return &everyone
+}
+
+// robotsAgent returns the product token of the User-Agent s sends, which
+// robots.txt groups are matched against
+func robotsAgent(s *Source) string {
+	agent := "mailbot"
+	for k, v := range s.network.Headers {
+		if strings.EqualFold(k, "User-Agent") && v != "" {
+			agent = v
+		}
+	}
+	if i := strings.IndexAny(agent, "/ "); i >= 0 {
+		agent = agent[:i]
+	}
+	return strings.ToLower(agent)
+}
+
+// robotsCache holds the robots.txt rules of every site, by scheme and host
+type robotsCache struct {
+	mu    sync.Mutex
+	sites map[string]*robotsSite
+}
+
+// robotsSite is the rules of a site; mu is held while they are fetched
+type robotsSite struct {
+	mu    sync.Mutex
+	rules *robotsRules
+}
+
+// allowedByRobots reports whether the robots.txt of the site of rawurl lets
+// s fetch it, reading the file first if it isn't known or is out of date
+func (c *Crawler) allowedByRobots(s *Source, rawurl string) bool {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return false
+	}
+	site := u.Scheme + "://" + u.Host
+	c.robots.mu.Lock()
+	if c.robots.sites == nil {
+		c.robots.sites = make(map[string]*robotsSite)
+	}
+	entry := c.robots.sites[site]
+	if entry == nil {
+		entry = new(robotsSite)
+		c.robots.sites[site] = entry
+	}
+	c.robots.mu.Unlock()
+
+	entry.mu.Lock()
+	if entry.rules == nil || time.Now().After(entry.rules.expires) {
+		entry.rules = c.fetchRobots(s, site)
+	}
+	rules := entry.rules
+	entry.mu.Unlock()
+	return rules.allowed(robotsPath(u))
+}
+
+// robotsPath is what the rules are matched against: the path and query of
+// u, the path being / when u has none
+func robotsPath(u *url.URL) string {
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	if u.RawQuery != "" {
+		p += "?" + u.RawQuery
+	}
+	return p
+}
+
+// fetchRobots reads the robots.txt of site, raising the rate limit of s to
+// its Crawl-delay. It counts against no request budget.
+func (c *Crawler) fetchRobots(s *Source, site string) *robotsRules {
+	robotsURL := site + "/robots.txt"
+	s.throttle()
+	var (
+		start  = time.Now()
+		status int
+		body   []byte
+	)
+	c.logf(levelFetch, "Fetching: %s", robotsURL)
+	header := make(http.Header)
+	for k, v := range s.network.Headers {
+		header.Set(k, v)
+	}
+	resp, err := s.fetcher.Fetch(c.ctx, robotsURL, header)
+	if err == nil {
+		status, body = resp.StatusCode, resp.Body
+	}
+	c.auditFetch(s, robotsURL, start, status, body, err)
+
+	var rules *robotsRules
+	switch {
+	case err != nil || status >= 500:
+		// An unreachable robots.txt disallows everything, for a while
+		if err == nil {
+			err = fmt.Errorf("%s", resp.Status)
+		}
+		report(fmt.Errorf("%s: %v, not fetching from %s for %s", robotsURL, err, site, robotsRetry))
+		return &robotsRules{
+			rules:   []robotsRule{newRobotsRule(false, "/")},
+			expires: time.Now().Add(robotsRetry),
+		}
+	case status == http.StatusOK:
+		rules = parseRobots(body, robotsAgent(s))
+	default:
+		// No robots.txt, no rules
+		rules = new(robotsRules)
+	}
+	rules.expires = time.Now().Add(robotsTTL)
+	s.mu.Lock()
+	slower := rules.delay > s.network.RateLimit
+	s.mu.Unlock()
+	if slower {
+		s.setRateLimit(rules.delay)
+		c.logf(levelInfo, "%s: rate limit raised to the %s crawl delay of %s", s.Name, rules.delay, robotsURL)
+	}
+	return rules
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestRobotsPath(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://paste.debian.net", "/"},
+		{"http://paste.debian.net/", "/"},
+		{"https://pastebin.com/raw/abc", "/raw/abc"},
+		{"https://pastebin.com/archive?page=2", "/archive?page=2"},
+		{"https://pastebin.com?page=2", "/?page=2"},
+		{"http://slexy.org/raw/a%20b", "/raw/a%20b"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := robotsPath(u); got != tt.want {
+			t.Errorf("%s: path %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestParseRobots(t *testing.T) {
+	const robots = `
+User-agent: *
+Disallow: /private
+Allow: /private/open
+Disallow: /*.php$
+Crawl-delay: 2
+
+# mailbot gets its own group
+User-agent: mailbot
+User-agent: otherbot
+Disallow: /raw/
+Allow: /raw/ok
+Disallow: /archive?
+`
+	tests := []struct {
+		agent string
+		path  string
+		want  bool
+	}{
+		{"mailbot", "/", true},
+		{"mailbot", "/raw/abc", false},
+		{"mailbot", "/raw/ok", true},
+		{"mailbot", "/raw/okay", true},
+		{"mailbot", "/archive", true},
+		{"mailbot", "/archive?page=2", false},
+		// The * group doesn't apply to a robot with its own
+		{"mailbot", "/private", true},
+		{"otherbot", "/raw/abc", false},
+		{"somebot", "/private", false},
+		{"somebot", "/private/open/x", true},
+		{"somebot", "/index.php", false},
+		{"somebot", "/index.php?x", true},
+		{"somebot", "/raw/abc", true},
+	}
+	for _, tt := range tests {
+		rules := parseRobots([]byte(robots), tt.agent)
+		if got := rules.allowed(tt.path); got != tt.want {
+			t.Errorf("%s %s: allowed %v, want %v", tt.agent, tt.path, got, tt.want)
+		}
+	}
+	if d := parseRobots([]byte(robots), "somebot").delay; d.Seconds() != 2 {
+		t.Errorf("crawl delay %s, want 2s", d)
+	}
+	if rules := parseRobots([]byte("User-agent: *\nDisallow: /\n"), "mailbot"); rules.allowed("/") {
+		t.Errorf("Disallow: / allows /")
+	}
+	if rules := parseRobots([]byte("User-agent: *\nDisallow:\n"), "mailbot"); !rules.allowed("/x") {
+		t.Errorf("empty Disallow disallows /x")
+	}
+}
+
+func TestAllowedByRobots(t *testing.T) {
+	setupTest(t)
+	s := lookupSource("debian")
+	testFetcher.set("http://closed.test/robots.txt", http.StatusOK, "User-agent: *\nDisallow: /\n")
+	testFetcher.set("http://down.test/robots.txt", http.StatusServiceUnavailable, "")
+	testFetcher.set("http://open.test/robots.txt", http.StatusOK, "User-agent: *\nDisallow: /raw\n")
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"http://closed.test", false},
+		{"http://closed.test?page=2", false},
+		{"http://down.test", false},
+		{"http://open.test", true},
+		{"http://open.test/raw/1", false},
+		{"http://missing.test", true},
+	}
+	for _, tt := range tests {
+		if got := c.allowedByRobots(s, tt.url); got != tt.want {
+			t.Errorf("%s: allowed %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
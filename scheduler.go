@@ -0,0 +1,126 @@
+package main
+
+import (
+	"sync"
+)
+
+// scheduler hands out paste fetches to the sources waiting for one. The
+// sources with the highest priority go first; among equal ones smooth
+// weighted round-robin gives each a share of the fetches proportional to
+// its weight, interleaved rather than in runs. No source has more than its
+// concurrency fetches running, nor all of them together more than limit
+// (0 for no limit). Nothing is handed out in a cycle before every source
+// has listed its archive, so the first to list doesn't take every slot.
+type scheduler struct {
+	mu      sync.Mutex
+	limit   int
+	running int
+	listing int
+	waiting map[*Source][]chan struct{}
+}
+
+// beginCycle holds fetches back until n sources called listed
+func (c *Crawler) beginCycle(n int) {
+	c.sched.mu.Lock()
+	c.sched.listing = n
+	c.sched.mu.Unlock()
+}
+
+// listed tells the scheduler a source is done listing its archive
+func (c *Crawler) listed() {
+	c.sched.mu.Lock()
+	if c.sched.listing > 0 {
+		c.sched.listing--
+	}
+	c.sched.dispatchLocked()
+	c.sched.mu.Unlock()
+}
+
+// acquire blocks until s may start a fetch. It reports false, taking
+// nothing, if the crawler stopped first.
+func (c *Crawler) acquire(s *Source) bool {
+	q := &c.sched
+	granted := make(chan struct{})
+	q.mu.Lock()
+	if q.waiting == nil {
+		q.waiting = make(map[*Source][]chan struct{})
+	}
+	q.waiting[s] = append(q.waiting[s], granted)
+	q.dispatchLocked()
+	q.mu.Unlock()
+	select {
+	case <-granted:
+		return true
+	case <-c.ctx.Done():
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, w := range q.waiting[s] {
+		if w == granted {
+			q.waiting[s] = append(q.waiting[s][:i], q.waiting[s][i+1:]...)
+			return false
+		}
+	}
+	// Granted meanwhile
+	q.releaseLocked(s)
+	return false
+}
+
+// release ends a fetch started after acquire
+func (c *Crawler) release(s *Source) {
+	c.sched.mu.Lock()
+	c.sched.releaseLocked(s)
+	c.sched.mu.Unlock()
+}
+
+func (q *scheduler) releaseLocked(s *Source) {
+	q.running--
+	s.running--
+	q.dispatchLocked()
+}
+
+// dispatchLocked grants fetches for as long as there are free slots and
+// sources waiting for them. The caller holds q.mu.
+func (q *scheduler) dispatchLocked() {
+	for q.listing == 0 && (q.limit == 0 || q.running < q.limit) {
+		s := q.nextLocked()
+		if s == nil {
+			return
+		}
+		close(q.waiting[s][0])
+		q.waiting[s] = q.waiting[s][1:]
+		q.running++
+		s.running++
+	}
+}
+
+// nextLocked picks the source to grant a fetch to, or nil
+func (q *scheduler) nextLocked() *Source {
+	var ready []*Source
+	for _, s := range sources {
+		if len(q.waiting[s]) == 0 || s.running >= s.concurrency {
+			continue
+		}
+		if len(ready) > 0 && s.priority < ready[0].priority {
+			continue
+		}
+		if len(ready) > 0 && s.priority > ready[0].priority {
+			ready = ready[:0]
+		}
+		ready = append(ready, s)
+	}
+	if len(ready) == 0 {
+		return nil
+	}
+	var best *Source
+	total := 0
+	for _, s := range ready {
+		s.current += s.weight
+		total += s.weight
+		if best == nil || s.current > best.current {
+			best = s
+		}
+	}
+	best.current -= total
+	return best
+}
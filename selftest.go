@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// selftestLinks are the archive entries of each source, with the paste ID
+// left to fill in, in the markup its Link pattern expects
+var selftestLinks = map[string]string{
+	"pastebin": `<tr><td><img class="i_p0" alt="" /><a href="/%s">selftest</a></td></tr>`,
+	"debian":   `<li><a href='//paste.debian.net/%s'>selftest</a></li>`,
+	"slexy":    `<a href="/view/%s">selftest</a>`,
+}
+
+// selftestListed is how many pastes, the newest, an archive page lists
+const selftestListed = 50
+
+const selftestFiller = "lorem ipsum dolor sit amet, consectetur adipiscing elit. "
+
+// selftest is the mock paste site served with -selftest-server. Every
+// source posts -selftest-rate new pastes per second, each holding
+// -selftest-emails addresses never used before and padded to
+// -selftest-size bytes.
+type selftest struct {
+	addr    string
+	started time.Time
+	rate    float64
+	emails  int
+	size    int
+	latency time.Duration
+	limit   int
+
+	archives  int64
+	pastes    int64
+	limited   int64
+	addresses int64
+
+	mu      sync.Mutex
+	second  int64
+	perHost map[string]int
+}
+
+// startSelftest serves the mock site on a local port and points every
+// source at it
+func (c *Crawler) startSelftest() error {
+	if c.flags.selftestRate <= 0 || c.flags.selftestEmails < 0 {
+		return fmt.Errorf("-selftest-rate must be positive and -selftest-emails not negative")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	t := &selftest{
+		addr:    ln.Addr().String(),
+		started: time.Now(),
+		rate:    c.flags.selftestRate,
+		emails:  c.flags.selftestEmails,
+		size:    c.flags.selftestSize,
+		latency: c.flags.selftestDelay,
+		limit:   c.flags.selftestLimit,
+		perHost: make(map[string]int),
+	}
+	c.selftest = t
+	srv := &http.Server{Handler: t}
+	c.spawn("selftest server", func() {
+		go func() {
+			<-c.ctx.Done()
+			srv.Close()
+		}()
+		if err := srv.Serve(ln); err != http.ErrServerClosed {
+			report(err)
+		}
+	})
+	c.logf(levelInfo, "selftest: serving mock pastes on %s, %g per second per source", t.addr, t.rate)
+	return nil
+}
+
+// selftestTransport sends the requests for the sources' sites to the mock
+// site instead, keeping their Host
+type selftestTransport struct {
+	addr string
+	next http.RoundTripper
+}
+
+func (t *selftestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if sourceForHost(req.URL.Host) == nil {
+		return t.next.RoundTrip(req)
+	}
+	out := req.Clone(req.Context())
+	out.Host = req.URL.Host
+	out.URL.Scheme, out.URL.Host = "http", t.addr
+	return t.next.RoundTrip(out)
+}
+
+// sourceForHost returns the source whose archive is on host, or nil
+func sourceForHost(host string) *Source {
+	for _, s := range sources {
+		if u, err := url.Parse(s.Archive); err == nil && u.Host == host {
+			return s
+		}
+	}
+	return nil
+}
+
+func (t *selftest) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := sourceForHost(r.Host)
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if t.latency > 0 {
+		time.Sleep(t.latency)
+	}
+	if t.throttled(r.Host) {
+		atomic.AddInt64(&t.limited, 1)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	newest := int64(time.Since(t.started).Seconds() * t.rate)
+	archive, _ := url.Parse(s.Archive)
+	if strings.TrimSuffix(r.URL.Path, "/") == strings.TrimSuffix(archive.Path, "/") {
+		atomic.AddInt64(&t.archives, 1)
+		var b strings.Builder
+		for id := newest; id >= 0 && id > newest-selftestListed; id-- {
+			fmt.Fprintf(&b, selftestLinks[s.Name]+"\n", "st"+strconv.FormatInt(id, 10))
+		}
+		w.Write([]byte(b.String()))
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:], "st"), 10, 64)
+	if err != nil || id < 0 || id > newest {
+		http.NotFound(w, r)
+		return
+	}
+	atomic.AddInt64(&t.pastes, 1)
+	atomic.AddInt64(&t.addresses, int64(t.emails))
+	var b strings.Builder
+	for i := 0; i < t.emails; i++ {
+		fmt.Fprintf(&b, "contact u%d_%d@%s.example.com\n", id, i, s.Name)
+	}
+	for b.Len() < t.size {
+		b.WriteString(selftestFiller)
+	}
+	w.Write([]byte(b.String()))
+}
+
+// throttled counts a request for host and reports whether it is over
+// -selftest-limit requests in the current second
+func (t *selftest) throttled(host string) bool {
+	if t.limit <= 0 {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now := time.Now().Unix(); now != t.second {
+		t.second = now
+		t.perHost = make(map[string]int)
+	}
+	t.perHost[host]++
+	return t.perHost[host] > t.limit
+}
+
+// report prints what the mock site served
+func (t *selftest) report() {
+	took := time.Since(t.started).Seconds()
+	pastes := atomic.LoadInt64(&t.pastes)
+	c.logf(0, "selftest: served %d archive pages and %d pastes (%.1f/s) holding %d addresses in %.1fs, %d requests refused with 429",
+		atomic.LoadInt64(&t.archives), pastes, float64(pastes)/took, atomic.LoadInt64(&t.addresses), took, atomic.LoadInt64(&t.limited))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// sentry reports problems to a Sentry compatible server through its store
+// API
+type sentry struct {
+	store  string
+	auth   string
+	client *http.Client
+}
+
+// newSentry parses a DSN of the form scheme://key@host[:port][/path]/project
+func newSentry(dsn string) (*sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("sentry dsn: %v", err)
+	}
+	if u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return nil, fmt.Errorf("sentry dsn: want scheme://key@host/project")
+	}
+	i := strings.LastIndex(u.Path, "/")
+	path, project := u.Path[:i+1], u.Path[i+1:]
+	if project == "" {
+		return nil, fmt.Errorf("sentry dsn: no project id")
+	}
+	auth := "Sentry sentry_version=7, sentry_client=mailbot/1.0, sentry_key=" + u.User.Username()
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+	return &sentry{
+		store:  u.Scheme + "://" + u.Host + path + "api/" + project + "/store/",
+		auth:   auth,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// sentryDSN returns the -sentry-dsn flag or $SENTRY_DSN
+func (c *Crawler) sentryDSN() string {
+	if c.flags.sentry != "" {
+		return c.flags.sentry
+	}
+	return os.Getenv("SENTRY_DSN")
+}
+
+// capture sends an event to Sentry, if configured. It blocks until the
+// server answered.
+func (c *Crawler) capture(level, message string, tags map[string]string, extra map[string]interface{}) {
+	if c.sentry == nil {
+		return
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	host, _ := os.Hostname()
+	event := map[string]interface{}{
+		"event_id":    hex.EncodeToString(id),
+		"timestamp":   time.Now().UTC().Format("2006-01-02T15:04:05"),
+		"level":       level,
+		"logger":      "mailbot",
+		"platform":    "go",
+		"server_name": host,
+		"message":     message,
+		"tags":        tags,
+		"extra":       extra,
+	}
+	b, _ := json.Marshal(event)
+	req, err := http.NewRequest("POST", c.sentry.store, bytes.NewReader(b))
+	if err != nil {
+		report(err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", c.sentry.auth)
+	resp, err := c.sentry.client.Do(req)
+	if err != nil {
+		c.logf(levelInfo, "sentry: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		c.logf(levelInfo, "sentry: %s", resp.Status)
+	}
+}
+
+// capturePanic reports a panic of the calling goroutine with its stack and
+// panics again. It must be deferred.
+func (c *Crawler) capturePanic(s *Source, url string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	tags := map[string]string{}
+	extra := map[string]interface{}{"stack": string(debug.Stack())}
+	if s != nil {
+		tags["source"] = s.Name
+	}
+	if url != "" {
+		extra["url"] = url
+	}
+	c.capture("fatal", fmt.Sprintf("panic: %v", r), tags, extra)
+	panic(r)
+}
+
+// sourceFailed reports s once it failed -sentry-failures cycles in a row
+func (c *Crawler) sourceFailed(s *Source, url string, err error) {
+	n := atomic.AddInt32(&s.failures, 1)
+	if c.sentry == nil || int(n) != c.flags.sentryFailures {
+		return
+	}
+	c.spawn("sentry report", func() {
+		c.capture("error",
+			fmt.Sprintf("%s failed %d cycles in a row: %v", s.Name, n, err),
+			map[string]string{"source": s.Name},
+			map[string]interface{}{"url": url, "error": err.Error(), "failures": n},
+		)
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Ways of dividing the work between the instances of a fleet
+const (
+	shardBySource = "source"
+	shardByPaste  = "paste"
+)
+
+// shard is the part of the work this instance does. By source, it crawls
+// the enabled sources whose index in name order is its own modulo count;
+// with more shards than sources, the shards given the same source divide
+// the pastes it lists. By paste, it fetches the pastes whose URL hashes to
+// index.
+type shard struct {
+	index int
+	count int
+	by    string
+	// By source, the part of the pastes of each source crawled it fetches:
+	// those hashing to [0] among [1]
+	parts map[string][2]int
+}
+
+// parseShard parses "i/n", 1 <= i <= n
+func parseShard(spec, by string) (*shard, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	i, n, ok := strings.Cut(spec, "/")
+	index, err1 := strconv.Atoi(i)
+	count, err2 := strconv.Atoi(n)
+	if !ok || err1 != nil || err2 != nil || count < 1 || index < 1 || index > count {
+		return nil, fmt.Errorf("shard %q: want i/n with 1 <= i <= n", spec)
+	}
+	if by != shardBySource && by != shardByPaste {
+		return nil, fmt.Errorf("shard by %q: want %s or %s", by, shardBySource, shardByPaste)
+	}
+	return &shard{index: index - 1, count: count, by: by}, nil
+}
+
+// hashIndex returns the one of n buckets key hashes to
+func hashIndex(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// assign works out which of names, the enabled sources in order, the
+// shard crawls and which part of their pastes
+func (sh *shard) assign(names []string) {
+	sh.parts = make(map[string][2]int)
+	m := len(names)
+	for j, name := range names {
+		switch {
+		case sh.count <= m && j%sh.count == sh.index:
+			sh.parts[name] = [2]int{0, 1}
+		case sh.count > m && sh.index%m == j:
+			// Shards j, j+m, j+2m... below count share it
+			sh.parts[name] = [2]int{sh.index / m, (sh.count - j + m - 1) / m}
+		}
+	}
+}
+
+// setupShard applies -shard: sources other shards own are disabled, or,
+// when sharding by paste, pastes other shards own are skipped by Crawl.
+// The flags win over the config's "shard" and "shard_by".
+func (c *Crawler) setupShard() error {
+	spec, by := c.flags.shard, c.flags.shardBy
+	if c.config.Shard != nil && !isSet("shard") {
+		spec = *c.config.Shard
+	}
+	if c.config.ShardBy != nil && !isSet("shard-by") {
+		by = *c.config.ShardBy
+	}
+	sh, err := parseShard(spec, by)
+	if err != nil || sh == nil {
+		return err
+	}
+	c.shard = sh
+	if sh.by != shardBySource {
+		return nil
+	}
+	var names []string
+	for _, s := range sources {
+		if s.enabled {
+			names = append(names, s.Name)
+		}
+	}
+	sort.Strings(names)
+	sh.assign(names)
+	for _, s := range sources {
+		part, ok := sh.parts[s.Name]
+		switch {
+		case s.enabled && !ok:
+			c.logf(levelInfo, "%s: left to another shard", s.Name)
+			s.enabled = false
+		case ok && part[1] > 1:
+			c.logf(levelInfo, "%s: fetching part %d of %d of its pastes", s.Name, part[0]+1, part[1])
+		}
+	}
+	return nil
+}
+
+// ownsPaste reports whether this instance fetches the paste of s at url
+func (c *Crawler) ownsPaste(s *Source, url string) bool {
+	sh := c.shard
+	switch {
+	case sh == nil:
+		return true
+	case sh.by == shardByPaste:
+		return hashIndex(url, sh.count) == sh.index
+	}
+	part, ok := sh.parts[s.Name]
+	return !ok || part[1] == 1 || hashIndex(url, part[1]) == part[0]
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseShard(t *testing.T) {
+	tests := []struct {
+		spec, by string
+		want     *shard
+		wantErr  bool
+	}{
+		{"", shardBySource, nil, false},
+		{"1/1", shardBySource, &shard{index: 0, count: 1, by: shardBySource}, false},
+		{"2/3", shardByPaste, &shard{index: 1, count: 3, by: shardByPaste}, false},
+		{"0/3", shardBySource, nil, true},
+		{"4/3", shardBySource, nil, true},
+		{"1/0", shardBySource, nil, true},
+		{"1", shardBySource, nil, true},
+		{"a/b", shardBySource, nil, true},
+		{"1/2", "host", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseShard(tt.spec, tt.by)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q by %s: %+v, %v", tt.spec, tt.by, got, err)
+		}
+	}
+}
+
+func TestShardAssign(t *testing.T) {
+	names := []string{"debian", "pastebin", "slexy"}
+	tests := []struct {
+		index, count int
+		want         map[string][2]int
+	}{
+		{0, 1, map[string][2]int{"debian": {0, 1}, "pastebin": {0, 1}, "slexy": {0, 1}}},
+		{1, 3, map[string][2]int{"pastebin": {0, 1}}},
+		{0, 2, map[string][2]int{"debian": {0, 1}, "slexy": {0, 1}}},
+		{1, 2, map[string][2]int{"pastebin": {0, 1}}},
+		{3, 5, map[string][2]int{"debian": {1, 2}}},
+		{2, 5, map[string][2]int{"slexy": {0, 1}}},
+	}
+	for _, tt := range tests {
+		sh := &shard{index: tt.index, count: tt.count, by: shardBySource}
+		sh.assign(names)
+		if !reflect.DeepEqual(sh.parts, tt.want) {
+			t.Errorf("shard %d/%d: %v, want %v", tt.index+1, tt.count, sh.parts, tt.want)
+		}
+	}
+
+	// Whatever the fleet, every part of every source goes to one shard
+	for count := 1; count <= 8; count++ {
+		owners := make(map[string]map[int]int)
+		split := make(map[string]int)
+		for _, name := range names {
+			owners[name] = make(map[int]int)
+		}
+		for index := 0; index < count; index++ {
+			sh := &shard{index: index, count: count, by: shardBySource}
+			sh.assign(names)
+			for name, part := range sh.parts {
+				owners[name][part[0]]++
+				if k := split[name]; k != 0 && k != part[1] || part[0] >= part[1] {
+					t.Errorf("%d shards: %s part %d of %d", count, name, part[0], part[1])
+				}
+				split[name] = part[1]
+			}
+		}
+		for name, parts := range owners {
+			for part, n := range parts {
+				if n != 1 {
+					t.Errorf("%d shards: part %d of %s owned %d times", count, part, name, n)
+				}
+			}
+			if len(parts) == 0 || len(parts) != split[name] {
+				t.Errorf("%d shards: %d of the %d parts of %s crawled", count, len(parts), split[name], name)
+			}
+		}
+	}
+}
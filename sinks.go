@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink stores findings. Write is given a whole batch at a time so remote
+// sinks make one round-trip per batch rather than per address.
+type Sink interface {
+	// Kind names the sink in the config file's "sinks" section
+	Kind() string
+	Write(findings []Finding) error
+}
+
+// sinkKinds are the sinks that can be configured
+var sinkKinds = []string{"file", "webhook", "elasticsearch"}
+
+// isSinkKind reports whether name is one of sinkKinds
+func isSinkKind(name string) bool {
+	for _, kind := range sinkKinds {
+		if kind == name {
+			return true
+		}
+	}
+	return false
+}
+
+// fileSink appends one address per line to the output file
+type fileSink struct {
+	mu         sync.Mutex
+	f          *os.File
+	provenance bool
+}
+
+func (s *fileSink) Kind() string { return "file" }
+
+func (s *fileSink) Write(findings []Finding) error {
+	var b strings.Builder
+	for _, f := range findings {
+		b.WriteString(f.Email)
+		if s.provenance {
+			b.WriteString(provenanceSuffix(f))
+		}
+		b.WriteByte('\n')
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := appendLines(s.f, []byte(b.String())); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+// scrub removes the addresses drop matches from the file, which is then
+// reopened as scrubFile replaces it
+func (s *fileSink) scrub(drop func([]byte) bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := scrubFile(s.f.Name(), drop)
+	if n == 0 || err != nil {
+		return n, err
+	}
+	f, err := os.OpenFile(s.f.Name(), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return n, err
+	}
+	s.f.Close()
+	s.f = f
+	return n, nil
+}
+
+// webhookSink posts batches as {"findings": [...]} JSON
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *webhookSink) Kind() string { return "webhook" }
+
+func (s *webhookSink) Write(findings []Finding) error {
+	b, _ := json.Marshal(map[string][]Finding{"findings": findings})
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sink webhook: %s", resp.Status)
+	}
+	return nil
+}
+
+// elasticSink indexes findings through the Elasticsearch bulk API
+type elasticSink struct {
+	url    string
+	index  string
+	client *http.Client
+}
+
+func (s *elasticSink) Kind() string { return "elasticsearch" }
+
+func (s *elasticSink) Write(findings []Finding) error {
+	var b bytes.Buffer
+	action, _ := json.Marshal(map[string]map[string]string{"index": {"_index": s.index}})
+	for _, f := range findings {
+		doc, _ := json.Marshal(f)
+		b.Write(action)
+		b.WriteByte('\n')
+		b.Write(doc)
+		b.WriteByte('\n')
+	}
+	resp, err := s.client.Post(strings.TrimSuffix(s.url, "/")+"/_bulk", "application/x-ndjson", &b)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("elasticsearch: %s", resp.Status)
+	}
+	// A bulk request succeeds as a whole even if some of its items failed
+	var reply struct {
+		Errors bool `json:"errors"`
+	}
+	if json.Unmarshal(body, &reply) == nil && reply.Errors {
+		return fmt.Errorf("elasticsearch: some of %d documents were rejected", len(findings))
+	}
+	return nil
+}
+
+// Bounds of the wait before a failing sink is tried again
+const (
+	sinkRetryMin = time.Second
+	sinkRetryMax = time.Minute
+)
+
+// flushTickMin is the shortest period batches are checked at, however
+// short their interval
+const flushTickMin = time.Millisecond
+
+// batchedSink holds findings for a sink until size of them are waiting or
+// the oldest has waited interval. Full batches wait in queue for the sink's
+// writer; those that don't fit, or arrive while the sink is failing, go to
+// the spool.
+type batchedSink struct {
+	sink     Sink
+	size     int
+	interval time.Duration
+	queue    chan []Finding
+	spool    *spool
+
+	mu     sync.Mutex
+	buf    []Finding
+	oldest time.Time
+
+	// Used by the writer only
+	failing bool
+	retry   time.Duration
+}
+
+// openSinks creates the sinks set by flags, the output file first, and
+// starts their writers
+func (c *Crawler) openSinks(file *os.File) error {
+	sinks := append([]Sink{&fileSink{f: file, provenance: c.flags.provenance}}, c.remoteSinks()...)
+	for _, s := range sinks {
+		b := &batchedSink{
+			sink:     s,
+			size:     c.flags.batchSize,
+			interval: c.flags.batchInterval,
+			queue:    make(chan []Finding, c.flags.sinkBacklog),
+		}
+		if conf := c.config.Sinks[s.Kind()]; conf != nil {
+			if conf.BatchSize != nil {
+				b.size = *conf.BatchSize
+			}
+			if conf.BatchInterval != nil {
+				b.interval = time.Duration(*conf.BatchInterval)
+			}
+		}
+		if b.size < 1 || b.interval <= 0 {
+			return fmt.Errorf("%s sink: batch size and interval must be positive", s.Kind())
+		}
+		var err error
+		if b.spool, err = openSpool(c.flags.spool, s.Kind()); err != nil {
+			return err
+		}
+		c.sinks = append(c.sinks, b)
+	}
+	for _, b := range c.sinks {
+		b := b
+		c.spawn("flush "+b.sink.Kind(), func() {
+			tick := b.interval / 4
+			if tick < flushTickMin {
+				tick = flushTickMin
+			}
+			t := time.NewTicker(tick)
+			defer t.Stop()
+			for c.tick(t) {
+				b.mu.Lock()
+				if len(b.buf) > 0 && time.Since(b.oldest) >= b.interval {
+					c.flushLocked(b)
+				}
+				b.mu.Unlock()
+			}
+		})
+		c.spawn("sink "+b.sink.Kind(), func() { c.writeSink(b) })
+	}
+	return nil
+}
+
+// remoteSinks returns the sinks set by flags other than the output file
+func (c *Crawler) remoteSinks() []Sink {
+	client := &http.Client{Timeout: c.network(nil).Timeout}
+	var sinks []Sink
+	if c.flags.sinkWebhook != "" {
+		sinks = append(sinks, &webhookSink{c.flags.sinkWebhook, client})
+	}
+	if c.flags.elasticsearch != "" {
+		sinks = append(sinks, &elasticSink{c.flags.elasticsearch, c.flags.elasticIndex, client})
+	}
+	return sinks
+}
+
+// sink queues findings on every sink, handing those whose batch is full to
+// their writer
+func (c *Crawler) sink(findings []Finding) {
+	for _, b := range c.sinks {
+		b.mu.Lock()
+		if len(b.buf) == 0 {
+			b.oldest = time.Now()
+		}
+		b.buf = append(b.buf, findings...)
+		if len(b.buf) >= b.size {
+			c.flushLocked(b)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// flushLocked hands b's batch to its writer, or spools it when the writer
+// is behind. The caller holds b.mu.
+func (c *Crawler) flushLocked(b *batchedSink) {
+	batch := append([]Finding(nil), b.buf...)
+	b.buf = b.buf[:0]
+	select {
+	case b.queue <- batch:
+	default:
+		c.spill(b, batch)
+	}
+}
+
+// writeSink writes the batches queued for b, and the spooled ones whenever
+// the sink keeps up, until the crawler stops
+func (c *Crawler) writeSink(b *batchedSink) {
+	for {
+		if !b.failing && len(b.queue) == 0 && b.spool.hasPending() {
+			if err := b.spool.replay(b.size, func(batch []Finding) error { return c.deliver(b, batch) }); err != nil {
+				c.sinkFailed(b, err)
+			}
+		}
+		var retry <-chan time.Time
+		if b.failing {
+			retry = time.After(b.retry)
+		}
+		select {
+		case batch := <-b.queue:
+			if b.failing {
+				c.spill(b, batch)
+			} else if err := c.deliver(b, batch); err != nil {
+				c.spill(b, batch)
+				c.sinkFailed(b, err)
+			}
+		case <-retry:
+			// Replaying the spool tells whether the sink is back
+			b.failing = false
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver writes batch to b's sink, resetting the retry wait once it works
+func (c *Crawler) deliver(b *batchedSink, batch []Finding) error {
+	// Some may have been suppressed since they were found
+	if batch = c.unsuppressed(batch); len(batch) == 0 {
+		return nil
+	}
+	err := b.sink.Write(batch)
+	fields := map[string]interface{}{"sink": b.sink.Kind(), "records": len(batch)}
+	if err != nil {
+		fields["error"] = err.Error()
+	} else {
+		b.retry = 0
+	}
+	c.emit(eventSinkFlush, fields)
+	return err
+}
+
+// sinkFailed makes b's writer spool everything until the retry wait,
+// doubled on every failure, is over
+func (c *Crawler) sinkFailed(b *batchedSink, err error) {
+	report(err)
+	b.failing = true
+	if b.retry *= 2; b.retry < sinkRetryMin {
+		b.retry = sinkRetryMin
+	} else if b.retry > sinkRetryMax {
+		b.retry = sinkRetryMax
+	}
+	c.logf(levelInfo, "%s sink: spooling for %s", b.sink.Kind(), b.retry)
+}
+
+// spill spools batch for later. Findings that can't even be spooled are
+// lost, so that stops the crawler.
+func (c *Crawler) spill(b *batchedSink, batch []Finding) {
+	if err := b.spool.add(batch); err != nil {
+		report(err)
+		// Not from this goroutine, which shutdown waits for
+		go c.shutdown("sink failure", exitSink)
+		return
+	}
+	c.emit(eventSinkSpool, map[string]interface{}{"sink": b.sink.Kind(), "records": len(batch)})
+}
+
+// closeSinks writes what every sink still holds, once their writers have
+// stopped, spooling what a sink doesn't take
+func (c *Crawler) closeSinks() {
+	for _, b := range c.sinks {
+		b.mu.Lock()
+		batch := append([]Finding(nil), b.buf...)
+		b.buf = b.buf[:0]
+		b.mu.Unlock()
+		for queued := true; queued; {
+			select {
+			case more := <-b.queue:
+				batch = append(batch, more...)
+			default:
+				queued = false
+			}
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		if b.failing {
+			c.spill(b, batch)
+		} else if err := c.deliver(b, batch); err != nil {
+			report(err)
+			c.spill(b, batch)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Source is a paste site crawled for emails. Link matches the paste links
+// on the Archive page; its first group appended to Raw gives the raw paste.
+// Posted, if set, captures when the paste was posted from what follows its
+// link, in PostedLayout if set (see parsePosted).
+type Source struct {
+	Name         string
+	Site         string
+	Archive      string
+	Link         *regexp.Regexp
+	Raw          string
+	Posted       *regexp.Regexp
+	PostedLayout string
+
+	enabled     bool
+	bindAddress string
+	network     Network
+	concurrency int
+	maxRequests int
+	priority    int
+	weight      int
+	campaign    string
+	sinceFlag   string
+	untilFlag   string
+	since       time.Time
+	until       time.Time
+	requests    int64
+	fetcher     Fetcher
+
+	mu          sync.Mutex
+	next        time.Time
+	lastSuccess int64
+
+	minInterval time.Duration
+	maxInterval time.Duration
+	interval    time.Duration
+	nextPoll    time.Time
+
+	// Guarded by the scheduler
+	running int
+	current int
+
+	paused       int32
+	backoffUntil int64
+	failures     int32
+
+	// Used by the source's crawl only
+	everListed bool
+	unlisted   int
+}
+
+var sources = []*Source{
+	{
+		Name:    "pastebin",
+		Site:    "pastebin.com",
+		Archive: "https://pastebin.com/archive",
+		Link:    regexp.MustCompile(`class="i_p0" alt="" /><a href="(.*?)">`),
+		Raw:     "https://pastebin.com/raw",
+		Posted:  regexp.MustCompile(`<td[^>]*>\s*(\d+ \w+ ago)\s*</td>`),
+	},
+	{
+		Name:    "debian",
+		Site:    "paste.debian.net",
+		Archive: "http://paste.debian.net",
+		Link:    regexp.MustCompile(`<li><a href='//paste.debian.net(.*?)'>`),
+		Raw:     "http://paste.debian.net",
+	},
+	{
+		Name:    "slexy",
+		Site:    "slexy.org",
+		Archive: "http://slexy.org/recent",
+		Link:    regexp.MustCompile(`\/view(.*?)">`),
+		Raw:     "http://slexy.org/raw",
+	},
+}
+
+// lookupSource returns the source called name, or nil
+func lookupSource(name string) *Source {
+	for _, s := range sources {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// isPaused reports whether the source is skipped by new cycles
+func (s *Source) isPaused() bool {
+	return atomic.LoadInt32(&s.paused) != 0
+}
+
+// setPaused pauses or resumes the source from the next cycle on
+func (s *Source) setPaused(paused bool) {
+	var v int32
+	if paused {
+		v = 1
+	}
+	atomic.StoreInt32(&s.paused, v)
+}
+
+// throttle blocks until the source's rate limit allows another request
+func (s *Source) throttle() {
+	s.mu.Lock()
+	if s.network.RateLimit <= 0 {
+		s.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	if s.next.Before(now) {
+		s.next = now
+	}
+	wait := s.next.Sub(now)
+	s.next = s.next.Add(jitter(s.network.RateLimit, s.network.Jitter))
+	s.mu.Unlock()
+	if wait > 0 {
+		c.logf(levelDebug, "%s: rate limited, waiting %s", s.Name, wait.Round(time.Millisecond))
+	}
+	c.sleep(wait)
+}
+
+// setRateLimit changes the minimum delay between two requests
+func (s *Source) setRateLimit(d time.Duration) {
+	s.mu.Lock()
+	s.network.RateLimit = d
+	s.mu.Unlock()
+}
+
+// Crawl collects emails from the pastes listed on a source's archive page
+func (c *Crawler) Crawl(s *Source, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer c.capturePanic(s, s.Archive)
+	page, err := c.FetchPage(s, s.Archive)
+	c.listed()
+	if err != nil {
+		report(err)
+		if err == errBudget {
+			c.emit(eventThrottled, map[string]interface{}{"source": s.Name, "reason": err.Error()})
+		} else if err != errRobots {
+			c.sourceFailed(s, s.Archive, err)
+			c.sourceError(s, s.Archive, err)
+		}
+		return
+	}
+	atomic.StoreInt32(&s.failures, 0)
+	links := s.Link.FindAllSubmatch(page, -1)
+	var posted []time.Time
+	if s.windowed() {
+		posted = s.postedTimes(page, s.Link.FindAllSubmatchIndex(page, -1))
+	}
+	c.checkDrift(s, page, len(links))
+	c.count(metricListed, s.Name, int64(len(links)))
+	if links == nil {
+		c.logf(levelInfo, "%s: no raw link", s.Name)
+		return
+	}
+	// Raw pastes are fetched when the scheduler gives s a turn
+	var (
+		fetches sync.WaitGroup
+		skipped int64
+		outside int
+	)
+	for i, link := range links {
+		url := s.Raw + string(link[1])
+		if !c.ownsPaste(s, url) {
+			continue
+		}
+		if posted != nil && !posted[i].IsZero() && !s.inWindow(posted[i]) {
+			// Left for a run with another window
+			c.logf(levelDebug, "%s: posted %s, outside the time window", url, posted[i].Format(time.RFC3339))
+			outside++
+			continue
+		}
+		if !c.acquire(s) {
+			break
+		}
+		if c.exhausted(s) {
+			c.release(s)
+			atomic.AddInt64(&skipped, 1)
+			continue
+		}
+		if added, err := c.store.Add(kindPaste, s.pasteKey(url)); err != nil {
+			report(err)
+		} else if !added {
+			c.release(s)
+			c.count(metricSkipped, s.Name, 1)
+			continue
+		}
+		if c.jobs != nil {
+			c.dispatch(s, url)
+			c.release(s)
+			continue
+		}
+		fetches.Add(1)
+		c.spawn("fetch "+url, func() {
+			defer func() {
+				c.release(s)
+				fetches.Done()
+			}()
+			defer c.capturePanic(s, url)
+			page, err := c.FetchPage(s, url)
+			if err == errBudget {
+				// Not fetched, so leave it for the next cycle
+				atomic.AddInt64(&skipped, 1)
+				c.store.Remove(kindPaste, s.pasteKey(url))
+				return
+			}
+			if err == errRobots {
+				return
+			}
+			if err != nil {
+				report(err)
+				c.sourceError(s, url, err)
+				return
+			}
+			c.count(metricPastes, s.Name, 1)
+			c.GetMail(s, url, page)
+		})
+	}
+	fetches.Wait()
+	if outside > 0 {
+		c.logf(levelInfo, "%s: %d pastes outside the time window", s.Name, outside)
+	}
+	if skipped > 0 {
+		c.emit(eventThrottled, map[string]interface{}{"source": s.Name, "reason": errBudget.Error(), "skipped": skipped})
+		c.logf(levelInfo, "%s: %v, %d pastes skipped", s.Name, errBudget, skipped)
+	}
+}
+
+// allFailing reports whether every enabled source has failed
+// -max-consecutive-errors cycles in a row
+func (c *Crawler) allFailing() bool {
+	if c.flags.maxErrors <= 0 {
+		return false
+	}
+	enabled := false
+	for _, s := range sources {
+		if !s.enabled {
+			continue
+		}
+		enabled = true
+		if atomic.LoadInt32(&s.failures) < int32(c.flags.maxErrors) {
+			return false
+		}
+	}
+	return enabled
+}
+
+// sourceError records that fetching url from s failed for good
+func (c *Crawler) sourceError(s *Source, url string, err error) {
+	c.emit(eventSourceError, map[string]interface{}{"source": s.Name, "url": url, "error": err.Error()})
+}
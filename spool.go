@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// spool keeps, as JSON lines on disk, the findings a sink couldn't take in
+// time, until it can
+type spool struct {
+	path string
+
+	mu      sync.Mutex
+	f       *os.File
+	pending bool
+}
+
+// openSpool returns the spool of the sink kind under dir, holding what an
+// earlier run left there
+func openSpool(dir, kind string) (*spool, error) {
+	s := &spool{path: filepath.Join(dir, kind+".jsonl")}
+	if err := recoverLines(s.path); err != nil {
+		return nil, err
+	}
+	// A run that stopped while replaying left the rest in .sending
+	if err := s.merge(s.path + ".sending"); err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(s.path); err == nil && info.Size() > 0 {
+		s.pending = true
+	}
+	return s, nil
+}
+
+// add appends findings to the spool
+func (s *spool) add(findings []Finding) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		s.f = f
+	}
+	var b []byte
+	for _, f := range findings {
+		line, _ := json.Marshal(f)
+		b = append(append(b, line...), '\n')
+	}
+	if err := appendLines(s.f, b); err != nil {
+		return err
+	}
+	s.pending = true
+	return s.f.Sync()
+}
+
+// hasPending reports whether the spool holds findings
+func (s *spool) hasPending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending
+}
+
+// replay hands what the spool holds to deliver in batches of size. What
+// isn't delivered, from the first batch that fails on, stays spooled.
+func (s *spool) replay(size int, deliver func([]Finding) error) error {
+	sending := s.path + ".sending"
+	s.mu.Lock()
+	if s.f != nil {
+		s.f.Close()
+		s.f = nil
+	}
+	err := os.Rename(s.path, sending)
+	s.pending = false
+	s.mu.Unlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	f, err := os.Open(sending)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var (
+		batch   []Finding
+		scanner = bufio.NewScanner(f)
+		failed  error
+	)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var finding Finding
+		if json.Unmarshal(scanner.Bytes(), &finding) != nil {
+			continue
+		}
+		batch = append(batch, finding)
+		if len(batch) < size {
+			continue
+		}
+		if failed == nil {
+			failed = deliver(batch)
+		}
+		if failed != nil {
+			if err := s.add(batch); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		if failed == nil {
+			failed = deliver(batch)
+		}
+		if failed != nil {
+			if err := s.add(batch); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.Remove(sending); err != nil {
+		return err
+	}
+	return failed
+}
+
+// merge appends the findings in path to the spool and removes it
+func (s *spool) merge(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	var findings []Finding
+	for scanner.Scan() {
+		var finding Finding
+		if json.Unmarshal(scanner.Bytes(), &finding) == nil {
+			findings = append(findings, finding)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(findings) > 0 {
+		if err := s.add(findings); err != nil {
+			return err
+		}
+	}
+	return os.Remove(path)
+}
+
+// scrub removes the findings drop matches from the spool
+func (s *spool) scrub(drop func([]byte) bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f != nil {
+		// add reopens the file scrubFile replaces
+		s.f.Close()
+		s.f = nil
+	}
+	n, err := scrubFile(s.path, drop)
+	if info, serr := os.Stat(s.path); serr == nil && info.Size() == 0 {
+		s.pending = false
+	}
+	return n, err
+}
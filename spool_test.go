@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// findingsOf returns a finding for each address
+func findingsOf(mails ...string) []Finding {
+	fs := make([]Finding, len(mails))
+	for i, mail := range mails {
+		fs[i] = Finding{Email: mail, Source: "pastebin"}
+	}
+	return fs
+}
+
+func TestSpoolReplay(t *testing.T) {
+	setupTest(t)
+	errSink := errors.New("sink down")
+	tests := []struct {
+		name      string
+		spooled   []string
+		failAt    int // the delivery that fails, from 1, or 0
+		delivered [][]string
+		left      []string
+	}{
+		{"empty", nil, 0, nil, nil},
+		{"batches", []string{"a", "b", "c", "d", "e"}, 0, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}, nil},
+		{"exact batches", []string{"a", "b", "c", "d"}, 0, [][]string{{"a", "b"}, {"c", "d"}}, nil},
+		{"failure", []string{"a", "b", "c", "d", "e"}, 2, [][]string{{"a", "b"}}, []string{"c", "d", "e"}},
+		{"first fails", []string{"a", "b", "c"}, 1, nil, []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "mailbot")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		s, err := openSpool(dir, "webhook")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.spooled != nil {
+			if err := s.add(findingsOf(tt.spooled...)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var delivered [][]string
+		calls := 0
+		err = s.replay(2, func(batch []Finding) error {
+			calls++
+			if calls == tt.failAt {
+				return errSink
+			}
+			var mails []string
+			for _, f := range batch {
+				mails = append(mails, f.Email)
+			}
+			delivered = append(delivered, mails)
+			return nil
+		})
+		if tt.failAt > 0 && err != errSink || tt.failAt == 0 && err != nil {
+			t.Errorf("%s: replay: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(delivered, tt.delivered) {
+			t.Errorf("%s: delivered %q, want %q", tt.name, delivered, tt.delivered)
+		}
+		if s.hasPending() != (tt.left != nil) {
+			t.Errorf("%s: pending %v", tt.name, s.hasPending())
+		}
+		// What is left is replayed whole next time
+		var left []string
+		s.replay(100, func(batch []Finding) error {
+			for _, f := range batch {
+				left = append(left, f.Email)
+			}
+			return nil
+		})
+		if !reflect.DeepEqual(left, tt.left) {
+			t.Errorf("%s: left %q, want %q", tt.name, left, tt.left)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "webhook.jsonl.sending")); !os.IsNotExist(err) {
+			t.Errorf("%s: .sending left behind", tt.name)
+		}
+	}
+}
+
+func TestOpenSpool(t *testing.T) {
+	setupTest(t)
+	dir, err := ioutil.TempDir("", "mailbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// A run killed while spooling and replaying left a torn line and the
+	// findings it was sending
+	path := filepath.Join(dir, "webhook.jsonl")
+	ioutil.WriteFile(path, []byte(`{"email":"a"}`+"\n"+`{"email":"b`), 0600)
+	ioutil.WriteFile(path+".sending", []byte(`{"email":"c"}`+"\n"), 0600)
+
+	s, err := openSpool(dir, "webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.hasPending() {
+		t.Fatal("nothing pending")
+	}
+	var got []string
+	if err := s.replay(10, func(batch []Finding) error {
+		for _, f := range batch {
+			got = append(got, f.Email)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %q, want %q", got, want)
+	}
+}
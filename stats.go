@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// SourceStats are the runtime statistics of a source
+type SourceStats struct {
+	Source      string     `json:"source"`
+	Enabled     bool       `json:"enabled"`
+	Listed      int64      `json:"listed"`
+	Fetched     int64      `json:"fetched"`
+	Skipped     int64      `json:"skipped"`
+	Emails      int64      `json:"emails"`
+	Duplicates  int64      `json:"duplicates"`
+	Requests    int64      `json:"requests"`
+	Errors      int64      `json:"errors"`
+	ErrorRate   float64    `json:"error_rate"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+}
+
+// sourceStats returns the statistics of every source
+func (c *Crawler) sourceStats() []SourceStats {
+	snap := c.metrics.snapshot()
+	var stats []SourceStats
+	for _, s := range sources {
+		get := func(name string) int64 {
+			return snap[metricKey{name, s.Name}]
+		}
+		st := SourceStats{
+			Source:     s.Name,
+			Enabled:    s.enabled,
+			Listed:     get(metricListed),
+			Fetched:    get(metricPastes),
+			Skipped:    get(metricSkipped),
+			Emails:     get(metricEmails),
+			Duplicates: get(metricDuplicates),
+			Requests:   get(metricRequests),
+			Errors:     get(metricRequestErrors),
+		}
+		if st.Requests > 0 {
+			st.ErrorRate = float64(st.Errors) / float64(st.Requests)
+		}
+		if t := atomic.LoadInt64(&s.lastSuccess); t != 0 {
+			last := time.Unix(0, t)
+			st.LastSuccess = &last
+		}
+		stats = append(stats, st)
+	}
+	return stats
+}
+
+// serveStats writes the per-source statistics as JSON
+func (c *Crawler) serveStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(c.sourceStats())
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of keys kept in a Store
+const (
+	kindEmail = "email"
+	kindPaste = "paste"
+)
+
+// Store remembers what the crawler has already seen
+type Store interface {
+	// Add marks key of the given kind as seen and reports whether it
+	// was new
+	Add(kind, key string) (bool, error)
+	// Remove forgets key
+	Remove(kind, key string) error
+	// Search returns up to limit keys of kind containing substr,
+	// ignoring case
+	Search(kind, substr string, limit int) ([]string, error)
+}
+
+// memoryStore is a Store that lives as long as the process
+type memoryStore struct {
+	mu   sync.Mutex
+	seen map[string]map[string]bool
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{seen: make(map[string]map[string]bool)}
+}
+
+func (m *memoryStore) Add(kind, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := m.seen[kind]
+	if keys == nil {
+		keys = make(map[string]bool)
+		m.seen[kind] = keys
+	}
+	if keys[key] {
+		return false, nil
+	}
+	keys[key] = true
+	return true, nil
+}
+
+func (m *memoryStore) Remove(kind, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.seen[kind], key)
+	return nil
+}
+
+func (m *memoryStore) Search(kind, substr string, limit int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var found []string
+	substr = strings.ToLower(substr)
+	for key := range m.seen[kind] {
+		if strings.Contains(strings.ToLower(key), substr) {
+			found = append(found, key)
+		}
+	}
+	sort.Strings(found)
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	return found, nil
+}
+
+// evict forgets every key of kind
+func (m *memoryStore) evict(kind string) {
+	m.mu.Lock()
+	delete(m.seen, kind)
+	m.mu.Unlock()
+}
+
+// provenanceSuffix is the comment -provenance writes after the address of
+// f in plain output
+func provenanceSuffix(f Finding) string {
+	suffix := " # " + f.Source + " " + f.URL + " " + f.Time.UTC().Format(time.RFC3339)
+	if f.Campaign != "" {
+		suffix += " " + f.Campaign
+	}
+	return suffix
+}
+
+// parsePlain reads a line of plain output: an address, followed by where
+// and when it was found if written with -provenance
+func parsePlain(line string) Finding {
+	i := strings.Index(line, " #")
+	if i < 0 {
+		return Finding{Email: line}
+	}
+	f := Finding{Email: strings.TrimSpace(line[:i])}
+	fields := strings.Fields(line[i+2:])
+	if len(fields) < 3 {
+		return f
+	}
+	f.Source, f.URL = fields[0], fields[1]
+	f.Time, _ = time.Parse(time.RFC3339, fields[2])
+	if len(fields) > 3 {
+		f.Campaign = fields[3]
+	}
+	return f
+}
+
+// campaignKey scopes key to campaign, so that every campaign collects the
+// addresses and pastes it finds, even those another one found first
+func campaignKey(campaign, key string) string {
+	if campaign == "" {
+		return key
+	}
+	return campaign + ":" + key
+}
+
+// unscoped returns the address a key of kindEmail was made from with
+// campaignKey. Addresses hold no colon.
+func unscoped(key string) string {
+	if i := strings.Index(key, "sha256:"); i >= 0 {
+		return key[i:]
+	}
+	return key[strings.LastIndex(key, ":")+1:]
+}
+
+// pasteKey is the key url is remembered by as a paste of s
+func (s *Source) pasteKey(url string) string {
+	return campaignKey(s.campaign, url)
+}
+
+// preload marks every address already in the output file as seen, so
+// continuing an existing file doesn't append duplicates to it
+func (c *Crawler) preload(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			f := parsePlain(line)
+			campaign := c.flags.campaign
+			if f.Source != "" {
+				// As written with -provenance
+				campaign = f.Campaign
+			}
+			if _, err := c.store.Add(kindEmail, campaignKey(campaign, f.Email)); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// dedup returns the addresses in mails that campaign never saw before
+func (c *Crawler) dedup(campaign string, mails []string) (fresh []string, duplicates int) {
+	for _, mail := range mails {
+		added, err := c.store.Add(kindEmail, campaignKey(campaign, c.storeKey(mail)))
+		if err != nil {
+			report(err)
+			// Better a duplicate than a lost address
+			added = true
+		}
+		if !added {
+			duplicates++
+			continue
+		}
+		fresh = append(fresh, mail)
+	}
+	return fresh, duplicates
+}
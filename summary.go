@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// summarize prints a summary of the crawler's activity every interval, to
+// stderr and, when file is set, appended to file
+func (c *Crawler) summarize(interval time.Duration, file string) {
+	prev := c.metrics.snapshot()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for c.tick(t) {
+		snap := c.metrics.snapshot()
+		c.printSummary(formatSummary("summary of the last "+interval.String(), snap, prev), file)
+		prev = snap
+	}
+}
+
+// finalSummary prints a summary of the whole run, once the sinks are
+// flushed, if summaries are on
+func (c *Crawler) finalSummary() {
+	if c.flags.summary <= 0 {
+		return
+	}
+	took := time.Since(c.started).Round(time.Second)
+	c.printSummary(formatSummary("final summary after "+took.String(), c.metrics.snapshot(), nil), c.flags.summaryFile)
+}
+
+// printSummary prints text to stderr and, when file is set, appends it to
+// file, with the time
+func (c *Crawler) printSummary(text, file string) {
+	stamped := time.Now().Format(time.RFC3339) + " " + text
+	if c.tui == nil && !c.flags.quiet {
+		fmt.Fprint(os.Stderr, stamped)
+	}
+	if file == "" {
+		return
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		report(err)
+		return
+	}
+	f.WriteString(stamped)
+	f.Close()
+}
+
+// formatSummary describes the change from prev to snap, in total and per
+// source, under title
+func formatSummary(title string, snap, prev map[metricKey]int64) string {
+	delta := func(name, source string) int64 {
+		k := metricKey{name, source}
+		return snap[k] - prev[k]
+	}
+	var names []string
+	seen := make(map[string]bool)
+	for k := range snap {
+		if !seen[k.source] {
+			seen[k.source] = true
+			names = append(names, k.source)
+		}
+	}
+	sort.Strings(names)
+
+	var (
+		buf                                          bytes.Buffer
+		pastes, emails, duplicates, requests, errors int64
+	)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "  %-10s %d pastes, %d new emails, %d duplicates, %d requests, %d errors\n",
+			name+":",
+			delta(metricPastes, name),
+			delta(metricEmails, name),
+			delta(metricDuplicates, name),
+			delta(metricRequests, name),
+			delta(metricRequestErrors, name),
+		)
+		pastes += delta(metricPastes, name)
+		emails += delta(metricEmails, name)
+		duplicates += delta(metricDuplicates, name)
+		requests += delta(metricRequests, name)
+		errors += delta(metricRequestErrors, name)
+	}
+	return fmt.Sprintf("%s: %d pastes scanned, %d new emails, %d duplicates suppressed, %d requests, %d errors\n%s",
+		title, pastes, emails, duplicates, requests, errors, buf.String())
+}
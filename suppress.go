@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// suppressions are the addresses and domains mailbot must never emit, as
+// asked by their owners. They are kept in the -suppress file.
+type suppressions struct {
+	mu   sync.RWMutex
+	list *watchlist
+	// hashed holds the suppressed addresses as -redact hash writes them
+	hashed map[string]bool
+}
+
+// loadSuppressions reads the -suppress file, which need not exist yet
+func (c *Crawler) loadSuppressions() error {
+	list, err := loadWatchlist(c.flags.suppress)
+	if os.IsNotExist(err) {
+		list, err = &watchlist{addresses: make(map[string]bool)}, nil
+	}
+	if err != nil {
+		return err
+	}
+	c.suppressed.list = list
+	c.suppressed.hashed = make(map[string]bool)
+	for mail := range list.addresses {
+		c.suppressed.hashed[hashMail(mail)] = true
+	}
+	return nil
+}
+
+// isSuppressed reports whether mail must not be emitted
+func (c *Crawler) isSuppressed(mail string) bool {
+	c.suppressed.mu.RLock()
+	defer c.suppressed.mu.RUnlock()
+	return c.suppressed.list != nil && c.suppressed.list.match(mail) != ""
+}
+
+// unsuppressedMails returns mails without the suppressed addresses
+func (c *Crawler) unsuppressedMails(mails []string) []string {
+	kept := make([]string, 0, len(mails))
+	for _, mail := range mails {
+		if !c.isSuppressed(mail) {
+			kept = append(kept, mail)
+		}
+	}
+	return kept
+}
+
+// suppressedAs reports whether shown, an address as -redact writes it, is
+// that of a suppressed address. Masked addresses keep their domain, so they
+// match domain suppressions only; hashed ones hide it, so they match
+// address suppressions only.
+func (c *Crawler) suppressedAs(shown string) bool {
+	if !strings.HasPrefix(shown, "sha256:") {
+		return c.isSuppressed(shown)
+	}
+	c.suppressed.mu.RLock()
+	defer c.suppressed.mu.RUnlock()
+	return c.suppressed.hashed[shown]
+}
+
+// unsuppressed returns findings without those of suppressed addresses,
+// which may have been found before they were suppressed. Their addresses
+// are already redacted.
+func (c *Crawler) unsuppressed(findings []Finding) []Finding {
+	kept := make([]Finding, 0, len(findings))
+	for _, f := range findings {
+		if !c.suppressedAs(f.Email) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// suppress adds entries to the suppressions and the -suppress file, then
+// scrubs what matches them from the output, the spools, the store and the
+// recent findings. It returns the entries that were new and how many
+// records were scrubbed from files.
+func (c *Crawler) suppress(entries []string) ([]string, int, error) {
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || entry == "@" || strings.HasPrefix(entry, "#") || strings.ContainsAny(entry, " \t,;<>") {
+			return nil, 0, fmt.Errorf("%q is not an address or a domain", entry)
+		}
+	}
+	s := &c.suppressed
+	s.mu.Lock()
+	if s.list == nil {
+		s.list = &watchlist{addresses: make(map[string]bool)}
+	}
+	if s.hashed == nil {
+		s.hashed = make(map[string]bool)
+	}
+	var added []string
+	for _, entry := range entries {
+		if key := s.list.add(entry); key != "" {
+			added = append(added, key)
+			if strings.Contains(key, "@") {
+				s.hashed[hashMail(key)] = true
+			}
+		}
+	}
+	s.mu.Unlock()
+	if len(added) == 0 {
+		return nil, 0, nil
+	}
+	f, err := os.OpenFile(c.flags.suppress, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return added, 0, err
+	}
+	_, err = f.WriteString(strings.Join(added, "\n") + "\n")
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return added, 0, err
+	}
+	scrubbed, err := c.scrub(added)
+	c.emit(eventSuppressed, map[string]interface{}{"entries": len(added), "scrubbed": scrubbed})
+	return added, scrubbed, err
+}
+
+// scrub removes the findings of suppressed addresses from the output file,
+// the spool of every sink, the alerts file and the store. entries are the
+// suppressions the store is searched for. Records written under -redact
+// hash don't tell their domain, so domain suppressions leave them be. The
+// audit log is left alone: it is append-only by design, and its chain
+// would break.
+func (c *Crawler) scrub(entries []string) (int, error) {
+	dropMail := func(line []byte) bool {
+		return c.suppressedAs(parsePlain(string(line)).Email)
+	}
+	dropJSON := func(line []byte) bool {
+		var f Finding
+		return json.Unmarshal(line, &f) == nil && c.suppressedAs(f.Email)
+	}
+	dropAlert := func(line []byte) bool {
+		var a Alert
+		if json.Unmarshal(line, &a) != nil {
+			return false
+		}
+		if a.Email != "" && c.suppressedAs(a.Email) {
+			return true
+		}
+		for _, shown := range shownMails(a.Excerpt) {
+			if c.suppressedAs(shown) {
+				return true
+			}
+		}
+		return false
+	}
+	var (
+		output *fileSink
+		spools = make(map[string]*spool)
+	)
+	for _, b := range c.sinks {
+		if f, ok := b.sink.(*fileSink); ok {
+			output = f
+		}
+		spools[b.sink.Kind()] = b.spool
+	}
+	var (
+		scrubbed int
+		err      error
+	)
+	if output != nil {
+		scrubbed, err = output.scrub(dropMail)
+	} else {
+		scrubbed, err = scrubFile(c.flags.filename, dropMail)
+	}
+	if err != nil {
+		return scrubbed, err
+	}
+	for _, kind := range sinkKinds {
+		sp := spools[kind]
+		if sp == nil {
+			if sp, err = openSpool(c.flags.spool, kind); err != nil {
+				return scrubbed, err
+			}
+		}
+		n, err := sp.scrub(dropJSON)
+		scrubbed += n
+		if err != nil {
+			return scrubbed, err
+		}
+	}
+	if c.flags.alerts != "" {
+		c.alerts.mu.Lock()
+		n, err := scrubFile(c.flags.alerts, dropAlert)
+		if n > 0 && c.alerts.out != nil {
+			// alert opens the new file
+			c.alerts.out.Close()
+			c.alerts.out = nil
+		}
+		c.alerts.mu.Unlock()
+		scrubbed += n
+		if err != nil {
+			return scrubbed, err
+		}
+	}
+	c.recent.remove(func(f Finding) bool { return c.suppressedAs(f.Email) })
+	if c.store != nil {
+		c.suppressed.mu.RLock()
+		for mail := range c.suppressed.hashed {
+			entries = append(entries, mail)
+		}
+		c.suppressed.mu.RUnlock()
+		for _, entry := range entries {
+			keys, err := c.store.Search(kindEmail, entry, math.MaxInt32)
+			if err != nil {
+				return scrubbed, err
+			}
+			for _, key := range keys {
+				if c.suppressedAs(unscoped(key)) {
+					if err := c.store.Remove(kindEmail, key); err != nil {
+						return scrubbed, err
+					}
+				}
+			}
+		}
+	}
+	return scrubbed, nil
+}
+
+// scrubFile removes the lines of the file at path that drop matches,
+// replacing the file so a crash leaves either version. It returns how many
+// lines were removed; a missing file has none.
+func scrubFile(path string, drop func(line []byte) bool) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var (
+		kept    = make([]byte, 0, len(data))
+		removed int
+	)
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && drop(trimmed) {
+			removed++
+			continue
+		}
+		kept = append(kept, line...)
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	if err := ioutil.WriteFile(path+".tmp", kept, 0600); err != nil {
+		return 0, err
+	}
+	return removed, os.Rename(path+".tmp", path)
+}
+
+// Suppress adds the addresses and domains given to the -suppress file and
+// scrubs their findings from the output, the spools and the store. Without
+// any it lists the suppressions. A running crawler learns of them through
+// the control API's /suppress, or when restarted.
+func (c *Crawler) Suppress(entries []string) {
+	if len(entries) == 0 {
+		list := c.suppressed.list
+		var lines []string
+		for mail := range list.addresses {
+			lines = append(lines, mail)
+		}
+		sort.Strings(lines)
+		domains := append([]string(nil), list.domains...)
+		sort.Strings(domains)
+		out := bufio.NewWriter(os.Stdout)
+		for _, line := range append(lines, domains...) {
+			fmt.Fprintln(out, line)
+		}
+		out.Flush()
+		return
+	}
+	// The output is rewritten, so not while an instance appends to it
+	if err := c.lockOutput(); err != nil {
+		reportFatal(fmt.Errorf("%v; suppress through its API instead (POST /suppress)", err))
+		os.Exit(exitLocked)
+	}
+	c.store = c.newStore()
+	added, scrubbed, err := c.suppress(entries)
+	if err != nil {
+		reportFatal(err)
+		if len(added) == 0 {
+			os.Exit(exitConfig)
+		}
+		os.Exit(exitSink)
+	}
+	if len(added) == 0 {
+		report(errors.New("already suppressed"))
+		return
+	}
+	c.logf(0, "suppressed %s, scrubbed %d records", strings.Join(added, ", "), scrubbed)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScrubFile(t *testing.T) {
+	dropBob := func(line []byte) bool { return bytes.HasPrefix(line, []byte("bob@")) }
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		removed int
+	}{
+		{"nothing to drop", "alice@x.com\ncarol@x.com\n", "alice@x.com\ncarol@x.com\n", 0},
+		{"dropped", "alice@x.com\nbob@x.com\ncarol@x.com\n", "alice@x.com\ncarol@x.com\n", 1},
+		{"every line", "bob@x.com\nbob@y.com\n", "", 2},
+		{"without a last newline", "alice@x.com\nbob@x.com", "alice@x.com\n", 1},
+		{"blank lines kept", "\nbob@x.com\n\nalice@x.com\n", "\n\nalice@x.com\n", 1},
+		{"padded", "  bob@x.com \nalice@x.com\n", "alice@x.com\n", 1},
+	}
+	dir, err := ioutil.TempDir("", "mailbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.txt")
+	for _, tt := range tests {
+		if err := ioutil.WriteFile(path, []byte(tt.data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		removed, err := scrubFile(path, dropBob)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		got, _ := ioutil.ReadFile(path)
+		if removed != tt.removed || string(got) != tt.want {
+			t.Errorf("%s: removed %d, left %q; want %d, %q", tt.name, removed, got, tt.removed, tt.want)
+		}
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("%s.tmp left behind", path)
+	}
+	if removed, err := scrubFile(filepath.Join(dir, "missing"), dropBob); removed != 0 || err != nil {
+		t.Errorf("missing file: removed %d, %v", removed, err)
+	}
+}
+
+func TestWatchlistMatch(t *testing.T) {
+	w := &watchlist{addresses: make(map[string]bool)}
+	for _, entry := range []string{"Alice@Example.com", "@corp.org", "hidden.net", "# a comment", ""} {
+		w.add(entry)
+	}
+	tests := []struct {
+		mail string
+		want string
+	}{
+		{"alice@example.com", "alice@example.com"},
+		{"ALICE@example.COM", "alice@example.com"},
+		{"bob@example.com", ""},
+		{"bob@corp.org", "corp.org"},
+		{"bob@mail.corp.org", "corp.org"},
+		{"bob@notcorp.org", ""},
+		{"eve@hidden.net", "hidden.net"},
+	}
+	for _, tt := range tests {
+		if got := w.match(tt.mail); got != tt.want {
+			t.Errorf("%s: matched %q, want %q", tt.mail, got, tt.want)
+		}
+	}
+}
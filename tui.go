@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TUI is the live terminal dashboard shown with -tui
+type TUI struct {
+	mu      sync.Mutex
+	errors  []string
+	history []rateSample
+	restore func()
+}
+
+type rateSample struct {
+	at   time.Time
+	snap map[metricKey]int64
+}
+
+const (
+	tuiErrors  = 5
+	tuiRecent  = 10
+	rateWindow = time.Minute
+)
+
+// log keeps err for display instead of printing it over the dashboard
+func (t *TUI) log(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.errors = append(t.errors, time.Now().Format("15:04:05")+" "+err.Error())
+	if len(t.errors) > tuiErrors {
+		t.errors = t.errors[len(t.errors)-tuiErrors:]
+	}
+}
+
+// startTUI takes over the terminal, drawing the dashboard every second and
+// handling key presses until the user quits
+func (c *Crawler) startTUI() {
+	t := &TUI{}
+	restore, err := makeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		report(fmt.Errorf("tui: %v, keys need Enter", err))
+		restore = func() {}
+	}
+	t.restore = restore
+	c.tui = t
+
+	// Not tracked: a read of stdin can't be interrupted
+	go func() {
+		in := bufio.NewReader(os.Stdin)
+		for {
+			b, err := in.ReadByte()
+			if err != nil {
+				return
+			}
+			switch {
+			case b == 'q':
+				c.shutdown("quit", 0)
+			case b == 'p':
+				// Pause everything unless all is paused already
+				pause := false
+				for _, s := range sources {
+					if !s.isPaused() {
+						pause = true
+					}
+				}
+				for _, s := range sources {
+					s.setPaused(pause)
+				}
+			case b >= '1' && b <= '9':
+				if i := int(b - '1'); i < len(sources) {
+					sources[i].setPaused(!sources[i].isPaused())
+				}
+			}
+		}
+	}()
+
+	fmt.Print("\x1b[?25l")
+	c.spawn("tui", func() {
+		for {
+			t.draw(c)
+			if !c.sleep(time.Second) {
+				return
+			}
+		}
+	})
+}
+
+// rates returns, per source, the pastes and emails per minute over the
+// last rateWindow
+func (t *TUI) rates(snap map[metricKey]int64) (pastes, emails map[string]float64) {
+	now := time.Now()
+	t.history = append(t.history, rateSample{now, snap})
+	for len(t.history) > 1 && now.Sub(t.history[0].at) > rateWindow {
+		t.history = t.history[1:]
+	}
+	pastes = make(map[string]float64)
+	emails = make(map[string]float64)
+	oldest := t.history[0]
+	minutes := now.Sub(oldest.at).Minutes()
+	if minutes == 0 {
+		return pastes, emails
+	}
+	for _, s := range sources {
+		p := metricKey{metricPastes, s.Name}
+		e := metricKey{metricEmails, s.Name}
+		pastes[s.Name] = float64(snap[p]-oldest.snap[p]) / minutes
+		emails[s.Name] = float64(snap[e]-oldest.snap[e]) / minutes
+	}
+	return pastes, emails
+}
+
+// draw renders one frame of the dashboard
+func (t *TUI) draw(c *Crawler) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pastes, emails := t.rates(c.metrics.snapshot())
+	var b bytes.Buffer
+	b.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&b, "mailbot  %s    [1-%d] pause/resume source  [p] all  [q] quit\n\n",
+		time.Now().Format("15:04:05"), len(sources))
+	fmt.Fprintf(&b, "  %-10s %-12s %7s %7s %7s %6s %9s %9s  %s\n",
+		"SOURCE", "STATE", "LISTED", "FETCHED", "EMAILS", "ERR%", "PASTE/MIN", "EMAIL/MIN", "LAST SUCCESS")
+	for i, st := range c.sourceStats() {
+		last := "never"
+		if st.LastSuccess != nil {
+			last = time.Since(*st.LastSuccess).Round(time.Second).String() + " ago"
+		}
+		fmt.Fprintf(&b, "%d %-10s %-12s %7d %7d %7d %5.1f%% %9.1f %9.1f  %s\n",
+			i+1, st.Source, sources[i].state(), st.Listed, st.Fetched, st.Emails,
+			st.ErrorRate*100, pastes[st.Source], emails[st.Source], last)
+	}
+
+	b.WriteString("\nRecent findings\n")
+	for _, f := range c.recent.list(tuiRecent) {
+		fmt.Fprintf(&b, "  %s %-10s %-40s %s\n", f.Time.Format("15:04:05"), f.Source, f.Email, f.URL)
+	}
+	b.WriteString("\nRecent errors\n")
+	for _, e := range t.errors {
+		fmt.Fprintf(&b, "  %s\n", e)
+	}
+	os.Stdout.Write(b.Bytes())
+}
+
+// state describes what the source is doing for the dashboard
+func (s *Source) state() string {
+	switch {
+	case !s.enabled:
+		return "disabled"
+	case s.isPaused():
+		return "paused"
+	}
+	if until := atomic.LoadInt64(&s.backoffUntil); until != 0 {
+		if wait := time.Until(time.Unix(0, until)); wait > 0 {
+			return "backoff " + wait.Round(time.Second).String()
+		}
+	}
+	return "running"
+}
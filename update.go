@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Caps on what is read from a release endpoint
+const (
+	maxReleaseFile = 1 << 20
+	maxBinary      = 256 << 20
+)
+
+// Set at build time, e.g. with
+// -ldflags "-X main.version=1.4.0 -X main.releaseURL=https://... -X main.releaseKey=..."
+var (
+	// version is the release mailbot was built from
+	version = "dev"
+	// releaseURL is where mailbot self-update looks for releases
+	releaseURL = ""
+	// releaseKey is the base64 ed25519 public key releases are signed with
+	releaseKey = ""
+)
+
+// A release endpoint serves, under its URL:
+//
+//	latest                         the newest version, e.g. 1.4.0
+//	<version>/SHA256SUMS           "version <version>", then
+//	                               "<sha256>  <file>" for every binary
+//	<version>/SHA256SUMS.sig       the base64 ed25519 signature of SHA256SUMS
+//	<version>/mailbot-<os>-<arch>  the binaries, .exe on Windows
+
+// releaseAsset is the name of the binary for this platform
+func releaseAsset() string {
+	name := "mailbot-" + runtime.GOOS + "-" + runtime.GOARCH
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// getRelease fetches url from the release endpoint, whole, as long as it
+// is no larger than maxReleaseFile
+func getRelease(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxReleaseFile+1))
+	if err == nil && len(b) > maxReleaseFile {
+		err = fmt.Errorf("%s: larger than %d bytes", url, maxReleaseFile)
+	}
+	return b, err
+}
+
+// parseVersion splits a version such as 1.4.0 or v1.5.0-rc1 into its
+// numbers and its pre-release suffix
+func parseVersion(v string) ([]int, string, bool) {
+	v = strings.TrimPrefix(v, "v")
+	v, pre, _ := strings.Cut(v, "-")
+	var nums []int
+	for _, part := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, "", false
+		}
+		nums = append(nums, n)
+	}
+	return nums, pre, true
+}
+
+// newerVersion reports whether version a is strictly newer than b. A
+// pre-release comes before the release it leads to.
+func newerVersion(a, b string) (bool, error) {
+	na, preA, okA := parseVersion(a)
+	nb, preB, okB := parseVersion(b)
+	if !okA || !okB {
+		return false, fmt.Errorf("can't compare versions %q and %q", a, b)
+	}
+	for i := 0; i < len(na) || i < len(nb); i++ {
+		var x, y int
+		if i < len(na) {
+			x = na[i]
+		}
+		if i < len(nb) {
+			y = nb[i]
+		}
+		if x != y {
+			return x > y, nil
+		}
+	}
+	switch {
+	case preA == preB:
+		return false, nil
+	case preA == "":
+		return true, nil
+	case preB == "":
+		return false, nil
+	}
+	return preA > preB, nil
+}
+
+// verifySums checks that sig, base64, is the signature of sums by key,
+// base64, and that sums is that of release, and returns the checksum sums
+// gives for asset. As the version is signed, an old release can't be
+// passed off as another.
+func verifySums(key string, sums, sig []byte, release, asset string) ([]byte, error) {
+	pub, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, errors.New("release key must be a base64 ed25519 public key")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil || !ed25519.Verify(pub, sums, raw) {
+		return nil, errors.New("SHA256SUMS is not signed by the release key")
+	}
+	lines := strings.Split(string(sums), "\n")
+	if fields := strings.Fields(lines[0]); len(fields) != 2 || fields[0] != "version" || fields[1] != release {
+		return nil, fmt.Errorf("SHA256SUMS is not that of release %s", release)
+	}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == asset {
+			sum, err := hex.DecodeString(fields[0])
+			if err != nil || len(sum) != sha256.Size {
+				return nil, fmt.Errorf("bad checksum for %s", asset)
+			}
+			return sum, nil
+		}
+	}
+	return nil, fmt.Errorf("release has no %s", asset)
+}
+
+// replaceExecutable writes the binary body, which must hash to sum, beside
+// the running executable and renames it over it, so that a failure at any
+// point leaves the old one in place
+func replaceExecutable(body io.Reader, sum []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	info, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(exe), "."+filepath.Base(exe)+".update")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), sum) {
+		return errors.New("downloaded binary doesn't match its signed checksum")
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()|0111); err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		// A running executable can't be replaced there, only moved
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), exe); err != nil {
+			os.Rename(old, exe)
+			return err
+		}
+		return nil
+	}
+	return os.Rename(tmp.Name(), exe)
+}
+
+// SelfUpdate replaces the running binary with the newest release, once
+// its checksum is verified against the release's signed SHA256SUMS
+func (c *Crawler) SelfUpdate() {
+	base := strings.TrimSuffix(c.flags.updateURL, "/")
+	if base == "" {
+		reportFatal(errors.New("self-update needs a release endpoint: -update-url"))
+		os.Exit(exitConfig)
+	}
+	if c.flags.updateKey == "" {
+		reportFatal(errors.New("self-update needs the key releases are signed with: -update-key"))
+		os.Exit(exitConfig)
+	}
+	client, err := newClient(c.network(nil))
+	if err != nil {
+		reportFatal(err)
+		os.Exit(exitConfig)
+	}
+	latest, err := getRelease(client, base+"/latest")
+	if err != nil {
+		reportFatal(err)
+		os.Exit(exitUpdate)
+	}
+	newest := strings.TrimSpace(string(latest))
+	if newest == "" || strings.ContainsAny(newest, "/ \t\r\n") {
+		reportFatal(fmt.Errorf("%s/latest: bad version %q", base, newest))
+		os.Exit(exitUpdate)
+	}
+	if newest == version && !c.flags.force {
+		c.logf(0, "mailbot %s is the latest release", version)
+		return
+	}
+	if newer, err := newerVersion(newest, version); err != nil && !c.flags.force {
+		reportFatal(fmt.Errorf("%v; -force to install %s anyway", err, newest))
+		os.Exit(exitUpdate)
+	} else if err == nil && !newer && !c.flags.force {
+		reportFatal(fmt.Errorf("%s offers %s, older than %s; -force to install it anyway", base, newest, version))
+		os.Exit(exitUpdate)
+	}
+	dir := base + "/" + newest
+	sums, err := getRelease(client, dir+"/SHA256SUMS")
+	if err != nil {
+		reportFatal(err)
+		os.Exit(exitUpdate)
+	}
+	sig, err := getRelease(client, dir+"/SHA256SUMS.sig")
+	if err != nil {
+		reportFatal(err)
+		os.Exit(exitUpdate)
+	}
+	asset := releaseAsset()
+	sum, err := verifySums(c.flags.updateKey, sums, sig, newest, asset)
+	if err != nil {
+		reportFatal(err)
+		os.Exit(exitUpdate)
+	}
+	// The binary may take longer than a single -timeout to download
+	client.Timeout = 0
+	resp, err := client.Get(dir + "/" + asset)
+	if err != nil {
+		reportFatal(err)
+		os.Exit(exitUpdate)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		reportFatal(fmt.Errorf("%s/%s: %s", dir, asset, resp.Status))
+		os.Exit(exitUpdate)
+	}
+	if err := replaceExecutable(io.LimitReader(resp.Body, maxBinary), sum); err != nil {
+		reportFatal(err)
+		os.Exit(exitUpdate)
+	}
+	c.logf(0, "updated mailbot from %s to %s", version, newest)
+}
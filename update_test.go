@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestNewerVersion(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		want    bool
+		wantErr bool
+	}{
+		{"1.4.0", "1.3.9", true, false},
+		{"1.3.9", "1.4.0", false, false},
+		{"1.4.0", "1.4.0", false, false},
+		{"v1.4.0", "1.4.0", false, false},
+		{"1.10.0", "1.9.0", true, false},
+		{"1.4.1", "1.4", true, false},
+		{"1.4", "1.4.0", false, false},
+		{"1.5.0", "1.5.0-rc1", true, false},
+		{"1.5.0-rc1", "1.5.0", false, false},
+		{"1.5.0-rc2", "1.5.0-rc1", true, false},
+		{"1.5.0-rc1", "1.4.9", true, false},
+		{"1.4.0", "dev", false, true},
+		{"1.x", "1.0", false, true},
+		{"-1.0", "1.0", false, true},
+	}
+	for _, tt := range tests {
+		got, err := newerVersion(tt.a, tt.b)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%s newer than %s: %v, %v", tt.a, tt.b, got, err)
+		}
+	}
+}
+
+func TestVerifySums(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, _ := ed25519.GenerateKey(nil)
+	key := base64.StdEncoding.EncodeToString(pub)
+	exe := sha256.Sum256([]byte("the binary"))
+	sums := []byte("version 1.4.0\n" +
+		strings.Repeat("0", 64) + "  mailbot-linux-arm64\n" +
+		hex.EncodeToString(exe[:]) + " *mailbot-linux-amd64\n" +
+		"zz  mailbot-windows-amd64.exe\n")
+	sign := func(b []byte) []byte {
+		return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, b)) + "\n")
+	}
+	tests := []struct {
+		name    string
+		key     string
+		sums    []byte
+		sig     []byte
+		release string
+		asset   string
+		wantErr string
+	}{
+		{"valid", key, sums, sign(sums), "1.4.0", "mailbot-linux-amd64", ""},
+		{"bad key", "not a key", sums, sign(sums), "1.4.0", "mailbot-linux-amd64", "release key must be"},
+		{"other key", base64.StdEncoding.EncodeToString(other), sums, sign(sums), "1.4.0", "mailbot-linux-amd64", "not signed"},
+		{"tampered", key, append(append([]byte(nil), sums...), '\n'), sign(sums), "1.4.0", "mailbot-linux-amd64", "not signed"},
+		{"bad signature", key, sums, []byte("!!"), "1.4.0", "mailbot-linux-amd64", "not signed"},
+		// An old release's signed sums can't be offered as a newer one
+		{"other release", key, sums, sign(sums), "1.5.0", "mailbot-linux-amd64", "not that of release 1.5.0"},
+		{"missing asset", key, sums, sign(sums), "1.4.0", "mailbot-darwin-arm64", "release has no"},
+		{"bad checksum", key, sums, sign(sums), "1.4.0", "mailbot-windows-amd64.exe", "bad checksum"},
+	}
+	for _, tt := range tests {
+		sum, err := verifySums(tt.key, tt.sums, tt.sig, tt.release, tt.asset)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.wantErr == "" && !bytes.Equal(sum, exe[:]):
+			t.Errorf("%s: checksum %x, want %x", tt.name, sum, exe)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// disposableDomains are throwaway mail providers; -disposable adds to them
+var disposableDomains = []string{
+	"10minutemail.com",
+	"dispostable.com",
+	"fakeinbox.com",
+	"getnada.com",
+	"guerrillamail.com",
+	"mailinator.com",
+	"maildrop.cc",
+	"mailnesia.com",
+	"mintemail.com",
+	"sharklasers.com",
+	"temp-mail.org",
+	"throwawaymail.com",
+	"trashmail.com",
+	"yopmail.com",
+}
+
+// Verdict is what mailbot validate found out about an address
+type Verdict struct {
+	Email       string   `json:"email"`
+	Valid       bool     `json:"valid"`
+	MX          []string `json:"mx,omitempty"`
+	Disposable  bool     `json:"disposable"`
+	Blacklisted bool     `json:"blacklisted"`
+	Problems    []string `json:"problems,omitempty"`
+}
+
+// validator checks addresses, looking up each domain's mail servers once
+type validator struct {
+	resolver   *net.Resolver
+	checkMX    bool
+	disposable *watchlist
+
+	mu  sync.Mutex
+	mxs map[string]*mxResult
+}
+
+// mxResult is the mail servers of a domain, or why there are none. done is
+// closed once they are known.
+type mxResult struct {
+	done    chan struct{}
+	hosts   []string
+	problem string
+}
+
+// newValidator returns a validator whose disposable domains include those
+// listed in the file at extra, if any
+func (c *Crawler) newValidator(extra string) (*validator, error) {
+	v := &validator{
+		resolver:   net.DefaultResolver,
+		checkMX:    c.flags.checkMX,
+		disposable: &watchlist{addresses: make(map[string]bool), domains: disposableDomains},
+		mxs:        make(map[string]*mxResult),
+	}
+	if extra != "" {
+		w, err := loadWatchlist(extra)
+		if err != nil {
+			return nil, err
+		}
+		v.disposable.domains = append(v.disposable.domains, w.domains...)
+	}
+	return v, nil
+}
+
+// validate runs every check on mail
+func (v *validator) validate(mail string) Verdict {
+	verdict := Verdict{Email: mail}
+	for _, black := range blacklist {
+		if strings.EqualFold(mail, black) {
+			verdict.Blacklisted = true
+			verdict.Problems = append(verdict.Problems, "blacklisted")
+			return verdict
+		}
+	}
+	if err := checkSyntax(mail); err != nil {
+		verdict.Problems = append(verdict.Problems, "syntax: "+err.Error())
+		return verdict
+	}
+	domain := strings.ToLower(mail[strings.LastIndex(mail, "@")+1:])
+	if v.disposable.match(mail) != "" {
+		verdict.Disposable = true
+		verdict.Problems = append(verdict.Problems, "disposable domain")
+	}
+	if v.checkMX {
+		mx := v.lookupMX(domain)
+		verdict.MX = mx.hosts
+		if mx.problem != "" {
+			verdict.Problems = append(verdict.Problems, mx.problem)
+		}
+	}
+	verdict.Valid = len(verdict.Problems) == 0
+	return verdict
+}
+
+// checkSyntax applies the rules of RFC 5321 to the plain addresses mailbot
+// collects: no quoted local parts, comments or address literals
+func checkSyntax(mail string) error {
+	at := strings.LastIndex(mail, "@")
+	if at < 0 {
+		return errors.New("no @")
+	}
+	local, domain := mail[:at], mail[at+1:]
+	switch {
+	case len(mail) > 254:
+		return errors.New("longer than 254 characters")
+	case local == "":
+		return errors.New("empty local part")
+	case len(local) > 64:
+		return errors.New("local part longer than 64 characters")
+	case strings.HasPrefix(local, ".") || strings.HasSuffix(local, ".") || strings.Contains(local, ".."):
+		return errors.New("misplaced dot in local part")
+	case !freshMail([]byte(mail)):
+		return errors.New("not an address the crawler keeps")
+	}
+	for _, r := range local {
+		if !(r < 128 && (isWord(byte(r)) || strings.ContainsRune(".!#$%&'*+/=?^`{|}~-", r))) {
+			return fmt.Errorf("%q not allowed in local part", r)
+		}
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return errors.New("domain has no dot")
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("bad domain label %q", label)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("domain label %q starts or ends with -", label)
+		}
+		for i := 0; i < len(label); i++ {
+			b := label[i]
+			if !(b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '-') {
+				return fmt.Errorf("%q not allowed in domain", b)
+			}
+		}
+	}
+	tld := labels[len(labels)-1]
+	for i := 0; i < len(tld); i++ {
+		if tld[i] >= '0' && tld[i] <= '9' {
+			return fmt.Errorf("numeric top-level domain %q", tld)
+		}
+	}
+	return nil
+}
+
+// lookupMX returns the mail servers of domain. A domain without MX records
+// but with an address receives mail itself, as RFC 5321 allows.
+func (v *validator) lookupMX(domain string) *mxResult {
+	v.mu.Lock()
+	r, ok := v.mxs[domain]
+	if ok {
+		v.mu.Unlock()
+		<-r.done
+		return r
+	}
+	r = &mxResult{done: make(chan struct{})}
+	v.mxs[domain] = r
+	v.mu.Unlock()
+	defer close(r.done)
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.network(nil).Timeout)
+	defer cancel()
+	records, err := v.resolver.LookupMX(ctx, domain)
+	for _, mx := range records {
+		if host := strings.TrimSuffix(mx.Host, "."); host != "" {
+			r.hosts = append(r.hosts, host)
+		}
+	}
+	if len(r.hosts) > 0 {
+		return r
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound || err == nil {
+		if addrs, err := v.resolver.LookupHost(ctx, domain); err == nil && len(addrs) > 0 {
+			r.hosts = []string{domain}
+			return r
+		}
+	}
+	switch {
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		r.problem = "domain doesn't exist"
+	case err != nil:
+		r.problem = "mx lookup failed: " + err.Error()
+	default:
+		r.problem = "no mail server"
+	}
+	return r
+}
+
+// Validate checks every address in the file at path, one per line ("-" for
+// stdin), and writes a line saying what is wrong with each, if anything
+func (c *Crawler) Validate(path string) {
+	var in io.Reader = os.Stdin
+	if path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			reportFatal(err)
+			os.Exit(exitConfig)
+		}
+		defer f.Close()
+		in = f
+	} else if path == "" {
+		reportFatal(errors.New("usage: mailbot validate [flags] file"))
+		os.Exit(exitConfig)
+	}
+	v, err := c.newValidator(c.flags.disposable)
+	if err != nil {
+		reportFatal(err)
+		os.Exit(exitConfig)
+	}
+	var mails []string
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			mails = append(mails, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		reportFatal(err)
+		os.Exit(exitConfig)
+	}
+
+	// Checked -concurrency at a time, written in the order read
+	verdicts := make([]chan Verdict, len(mails))
+	for i := range verdicts {
+		verdicts[i] = make(chan Verdict, 1)
+	}
+	slots := make(chan struct{}, c.flags.concurrency)
+	go func() {
+		for i, mail := range mails {
+			slots <- struct{}{}
+			go func(i int, mail string) {
+				verdicts[i] <- v.validate(mail)
+				<-slots
+			}(i, mail)
+		}
+	}()
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	var valid, disposable, blacklisted, noMX, badSyntax int
+	for i := range mails {
+		verdict := <-verdicts[i]
+		syntaxOK := len(verdict.Problems) == 0 || !strings.HasPrefix(verdict.Problems[0], "syntax")
+		if verdict.Valid {
+			valid++
+		}
+		if !syntaxOK {
+			badSyntax++
+		} else if v.checkMX && verdict.MX == nil && !verdict.Blacklisted {
+			noMX++
+		}
+		if verdict.Disposable {
+			disposable++
+		}
+		if verdict.Blacklisted {
+			blacklisted++
+		}
+		if c.flags.json {
+			b, _ := json.Marshal(verdict)
+			out.Write(append(b, '\n'))
+			continue
+		}
+		status := "ok"
+		notes := strings.Join(verdict.Problems, "; ")
+		if !verdict.Valid {
+			status = "invalid"
+		} else if len(verdict.MX) > 0 {
+			notes = "mx " + strings.Join(verdict.MX, ",")
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\n", verdict.Email, status, notes)
+	}
+	out.Flush()
+	c.logf(0, "validated %d addresses: %d valid, %d bad syntax, %d without mail server, %d disposable, %d blacklisted",
+		len(mails), valid, badSyntax, noMX, disposable, blacklisted)
+}
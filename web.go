@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// webCookie carries the API token for the dashboard's own requests
+const webCookie = "mailbot_token"
+
+// serveWeb serves the dashboard on addr until it fails. An address without
+// a host, such as :8080, is served on 127.0.0.1 only.
+func (c *Crawler) serveWeb(addr, token string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(dashboardHTML))
+	})
+	mux.HandleFunc("/api/stats", c.serveStats)
+	mux.HandleFunc("/ws", c.serveWebsocket)
+	mux.HandleFunc("/api/recent", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, c.recent.list(recentSize))
+	})
+	mux.HandleFunc("/api/search", func(w http.ResponseWriter, r *http.Request) {
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if limit <= 0 || limit > 1000 {
+			limit = 100
+		}
+		q := strings.TrimSpace(r.URL.Query().Get("q"))
+		keys, err := c.store.Search(kindEmail, q, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		found := []string{}
+		seen := make(map[string]bool)
+		for _, key := range keys {
+			mail := c.shown(key)
+			if !seen[mail] {
+				seen[mail] = true
+				found = append(found, mail)
+			}
+		}
+		writeJSON(w, found)
+	})
+	c.listen("web", &http.Server{Addr: localAddr(addr), Handler: webAuth(token, mux)})
+}
+
+// localAddr binds addr to the loopback interface when it names no host
+func localAddr(addr string) string {
+	if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+	return addr
+}
+
+// webAuth only lets requests carrying token through: as a bearer token, or
+// as the cookie a browser is given on opening the dashboard with ?token=
+func webAuth(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if cookie, err := r.Cookie(webCookie); err == nil && got == "" {
+			got = cookie.Value
+		}
+		if q := r.URL.Query().Get("token"); q != "" && r.URL.Path == "/" {
+			if subtle.ConstantTimeCompare([]byte(q), []byte(token)) == 1 {
+				http.SetCookie(w, &http.Cookie{
+					Name:     webCookie,
+					Value:    token,
+					Path:     "/",
+					HttpOnly: true,
+					SameSite: http.SameSiteStrictMode,
+				})
+				// Keep the token out of the history and the Referer
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+				return
+			}
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// shown is how the store key of an address is shown on the dashboard:
+// without its campaign, and redacted as in the output
+func (c *Crawler) shown(key string) string {
+	mail := unscoped(key)
+	if strings.HasPrefix(mail, "sha256:") {
+		return mail
+	}
+	return c.redact(mail)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>mailbot</title>
+<style>
+body { font: 14px sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { padding: 4px 10px; border-bottom: 1px solid #ddd; text-align: left; }
+td.n { text-align: right; }
+.bad { color: #b00; }
+input { padding: 4px; width: 20em; }
+</style>
+</head>
+<body>
+<h1>mailbot</h1>
+
+<h2>Sources</h2>
+<table id="sources">
+<tr><th>Source</th><th>Listed</th><th>Fetched</th><th>Skipped</th><th>Emails</th><th>Duplicates</th><th>Requests</th><th>Error rate</th><th>Last success</th></tr>
+</table>
+
+<h2>Recent findings</h2>
+<table id="recent"><tr><th>Time</th><th>Source</th><th>Email</th><th>Paste</th></tr></table>
+
+<h2>Search</h2>
+<input id="q" placeholder="address or domain"> <span id="count"></span>
+<table id="results"></table>
+
+<script>
+function cell(row, text, cls) {
+	var td = row.insertCell();
+	td.textContent = text;
+	if (cls) td.className = cls;
+	return td;
+}
+function clear(table) {
+	while (table.rows.length > 1) table.deleteRow(1);
+}
+function refresh() {
+	fetch("/api/stats").then(r => r.json()).then(stats => {
+		var t = document.getElementById("sources");
+		clear(t);
+		stats.forEach(s => {
+			var row = t.insertRow();
+			cell(row, s.source + (s.enabled ? "" : " (disabled)"));
+			[s.listed, s.fetched, s.skipped, s.emails, s.duplicates, s.requests].forEach(n => cell(row, n, "n"));
+			cell(row, (s.error_rate * 100).toFixed(1) + "%", s.error_rate > 0.5 ? "n bad" : "n");
+			cell(row, s.last_success ? new Date(s.last_success).toLocaleTimeString() : "never");
+		});
+	});
+	fetch("/api/recent").then(r => r.json()).then(recent => {
+		var t = document.getElementById("recent");
+		clear(t);
+		recent.slice(0, 25).forEach(f => {
+			var row = t.insertRow();
+			cell(row, new Date(f.time).toLocaleTimeString());
+			cell(row, f.source);
+			cell(row, f.email);
+			var a = document.createElement("a");
+			a.href = f.url;
+			a.textContent = f.url;
+			row.insertCell().appendChild(a);
+		});
+	});
+}
+document.getElementById("q").addEventListener("input", e => {
+	fetch("/api/search?q=" + encodeURIComponent(e.target.value)).then(r => r.json()).then(found => {
+		var t = document.getElementById("results");
+		while (t.rows.length) t.deleteRow(0);
+		found.forEach(m => cell(t.insertRow(), m));
+		document.getElementById("count").textContent = found.length + " shown";
+	});
+});
+refresh();
+setInterval(refresh, 2000);
+</script>
+</body>
+</html>
+`
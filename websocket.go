@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// A minimal RFC 6455 server: the crawler only pushes text messages, and
+// only reads the client's frames to answer pings and notice it leaving.
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+)
+
+// serveWebsocket pushes every new finding as a JSON text message. It is
+// served behind the dashboard's token check, and browsers may only open it
+// from the dashboard or from an origin in -web-origins.
+func (c *Crawler) serveWebsocket(w http.ResponseWriter, r *http.Request) {
+	if !c.allowedOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" ||
+		r.Header.Get("Sec-WebSocket-Key") == "" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		report(err)
+		return
+	}
+	defer conn.Close()
+
+	sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + websocketGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	findings, cancel := c.broker.subscribe()
+	defer cancel()
+	ws := &wsConn{conn: conn}
+	done := make(chan struct{})
+	c.spawn("websocket reader", func() {
+		defer close(done)
+		ws.readLoop(rw.Reader)
+	})
+	for {
+		select {
+		case <-done:
+			return
+		case <-c.ctx.Done():
+			// Hijacked connections aren't closed with the server
+			ws.write(wsClose, nil)
+			conn.Close()
+			<-done
+			return
+		case f := <-findings:
+			msg, _ := json.Marshal(f)
+			if err := ws.write(wsText, msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// allowedOrigin reports whether r comes from a page allowed to open /ws:
+// one served by the same host, or one in -web-origins. Clients other than
+// browsers send no Origin, and are only held to the token.
+func (c *Crawler) allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range strings.Split(c.flags.webOrigins, ",") {
+		if allowed = strings.TrimSpace(allowed); allowed != "" && strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// wsConn serializes frame writes from the push and read loops
+type wsConn struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (ws *wsConn) write(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	_, err := ws.conn.Write(append(header, payload...))
+	return err
+}
+
+// readLoop answers pings until the client closes or the connection fails
+func (ws *wsConn) readLoop(r *bufio.Reader) {
+	for {
+		opcode, payload, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsClose:
+			ws.write(wsClose, payload)
+			return
+		case wsPing:
+			ws.write(wsPong, payload)
+		}
+	}
+}
+
+const wsMaxPayload = 64 << 10
+
+// readFrame reads one masked client frame
+func readFrame(r *bufio.Reader) (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := head[0] & 0x0F
+	if head[1]&0x80 == 0 {
+		return 0, nil, errors.New("websocket: unmasked client frame")
+	}
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxPayload {
+		return 0, nil, errors.New("websocket: frame too large")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeLayouts are the forms -since and -until accept, besides a duration
+// back from now
+var timeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// parseBound reads a bound of the time window: a time in one of
+// timeLayouts, in local time unless it says otherwise, or a duration
+// before now such as 48h. Empty means no bound.
+func parseBound(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a time like 2006-01-02 15:04 nor a duration", s)
+}
+
+// setupWindow resolves the time window of every source. Its config entry
+// wins over its -<source>-since and -<source>-until flags, which win over
+// -since and -until.
+func (c *Crawler) setupWindow() error {
+	now := time.Now()
+	for _, s := range sources {
+		since, until := c.flags.since, c.flags.until
+		if s.sinceFlag != "" {
+			since = s.sinceFlag
+		}
+		if s.untilFlag != "" {
+			until = s.untilFlag
+		}
+		if sc, ok := c.config.Sources[s.Name]; ok {
+			if sc.Since != nil {
+				since = *sc.Since
+			}
+			if sc.Until != nil {
+				until = *sc.Until
+			}
+			if sc.Posted != nil {
+				posted, err := regexp.Compile(*sc.Posted)
+				if err != nil {
+					return fmt.Errorf("%s: posted: %v", s.Name, err)
+				}
+				s.Posted = posted
+			}
+			if sc.PostedLayout != nil {
+				s.PostedLayout = *sc.PostedLayout
+			}
+		}
+		var err error
+		if s.since, err = parseBound(since, now); err != nil {
+			return fmt.Errorf("%s: since: %v", s.Name, err)
+		}
+		if s.until, err = parseBound(until, now); err != nil {
+			return fmt.Errorf("%s: until: %v", s.Name, err)
+		}
+		if !s.since.IsZero() && !s.until.IsZero() && !s.since.Before(s.until) {
+			return fmt.Errorf("%s: the time window ends before it starts", s.Name)
+		}
+		if s.Posted != nil && s.Posted.NumSubexp() < 1 {
+			return fmt.Errorf("%s: posted must capture the time in a group", s.Name)
+		}
+	}
+	return nil
+}
+
+// windowed reports whether s only takes the pastes of a time window
+func (s *Source) windowed() bool {
+	return !s.since.IsZero() || !s.until.IsZero()
+}
+
+// inWindow reports whether a paste posted at t is within the window of s
+func (s *Source) inWindow(t time.Time) bool {
+	return (s.since.IsZero() || !t.Before(s.since)) && (s.until.IsZero() || t.Before(s.until))
+}
+
+// relativeTime matches the ages archives show instead of dates
+var relativeTime = regexp.MustCompile(`^(\d+|an?) (sec|second|min|minute|hour|day|week|month|year)s? ago$`)
+
+// relativeUnits are the lengths of the units of relativeTime; months and
+// years are approximate
+var relativeUnits = map[string]time.Duration{
+	"sec":    time.Second,
+	"second": time.Second,
+	"min":    time.Minute,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+	"week":   7 * 24 * time.Hour,
+	"month":  30 * 24 * time.Hour,
+	"year":   365 * 24 * time.Hour,
+}
+
+// parsePosted reads the time a paste was posted as an archive shows it:
+// in layout if set, else RFC 3339, one of timeLayouts or an age such as
+// "5 min ago"
+func parsePosted(text, layout string, now time.Time) (time.Time, bool) {
+	text = strings.Join(strings.Fields(text), " ")
+	if layout != "" {
+		t, err := time.ParseInLocation(layout, text, time.Local)
+		return t, err == nil
+	}
+	if m := relativeTime.FindStringSubmatch(strings.ToLower(text)); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			n = 1 // "a" or "an"
+		}
+		return now.Add(-time.Duration(n) * relativeUnits[m[2]]), true
+	}
+	for _, l := range timeLayouts {
+		if t, err := time.ParseInLocation(l, text, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// postedTimes returns when each paste of links was posted, if the archive
+// shows it: Posted is searched between a link and the next one.
+func (s *Source) postedTimes(page []byte, links [][]int) []time.Time {
+	times := make([]time.Time, len(links))
+	if s.Posted == nil {
+		return times
+	}
+	now := time.Now()
+	for i, link := range links {
+		end := len(page)
+		if i+1 < len(links) {
+			end = links[i+1][0]
+		}
+		if m := s.Posted.FindSubmatch(page[link[1]:end]); m != nil {
+			times[i], _ = parsePosted(string(m[1]), s.PostedLayout, now)
+		}
+	}
+	return times
+}